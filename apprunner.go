@@ -0,0 +1,70 @@
+package ecrm
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apprunner"
+)
+
+// appRunnerClient is an interface of App Runner client to list and describe services.
+type appRunnerClient interface {
+	apprunner.ListServicesAPIClient
+	DescribeService(ctx context.Context, params *apprunner.DescribeServiceInput, optFns ...func(*apprunner.Options)) (*apprunner.DescribeServiceOutput, error)
+}
+
+func (s *Scanner) scanAppRunnerServices(ctx context.Context, acs []*AppRunnerConfig) error {
+	if len(acs) == 0 {
+		return nil
+	}
+	p := apprunner.NewListServicesPaginator(s.apprunner, &apprunner.ListServicesInput{})
+	for p.HasMorePages() {
+		r, err := p.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list App Runner services: %w", err)
+		}
+		for _, sv := range r.ServiceSummaryList {
+			name := aws.ToString(sv.ServiceName)
+			matched := false
+			for _, ac := range acs {
+				if ac.Match(name) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				continue
+			}
+			if err := s.scanAppRunnerServiceArn(ctx, aws.ToString(sv.ServiceArn)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Scanner) scanAppRunnerServiceArn(ctx context.Context, serviceArn string) error {
+	log.Println("[debug] Describing App Runner service", serviceArn)
+	out, err := s.apprunner.DescribeService(ctx, &apprunner.DescribeServiceInput{
+		ServiceArn: &serviceArn,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe App Runner service %s: %w", serviceArn, err)
+	}
+	src := out.Service.SourceConfiguration
+	if src == nil || src.ImageRepository == nil {
+		// the service is built from a source code repository
+		return nil
+	}
+	u := ImageURI(aws.ToString(src.ImageRepository.ImageIdentifier))
+	if !u.IsECRImage() {
+		log.Printf("[debug] Skipping non ECR image %s", u)
+		return nil
+	}
+	if s.Images.AddConsumer(u, newConsumer(ConsumerTypeAppRunnerService, serviceArn)) {
+		log.Printf("[info] %s is in use by App Runner service %s", u.String(), serviceArn)
+	}
+	return nil
+}
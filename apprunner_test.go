@@ -0,0 +1,112 @@
+package ecrm_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apprunner"
+	apprunnerTypes "github.com/aws/aws-sdk-go-v2/service/apprunner/types"
+	"github.com/fujiwara/ecrm"
+	"github.com/google/go-cmp/cmp"
+)
+
+const testAppRunnerArnPrefix = "arn:aws:apprunner:ap-northeast-1:012345678901:service/"
+
+type fakeAppRunner struct {
+	pages     [][]string                                     // pages of service names
+	sources   map[string]*apprunnerTypes.SourceConfiguration // service name -> source
+	describes []string
+}
+
+func (f *fakeAppRunner) ListServices(ctx context.Context, in *apprunner.ListServicesInput, _ ...func(*apprunner.Options)) (*apprunner.ListServicesOutput, error) {
+	page := 0
+	if in.NextToken != nil {
+		page, _ = strconv.Atoi(aws.ToString(in.NextToken))
+	}
+	out := &apprunner.ListServicesOutput{}
+	for _, name := range f.pages[page] {
+		out.ServiceSummaryList = append(out.ServiceSummaryList, apprunnerTypes.ServiceSummary{
+			ServiceName: aws.String(name),
+			ServiceArn:  aws.String(testAppRunnerArnPrefix + name),
+		})
+	}
+	if page+1 < len(f.pages) {
+		out.NextToken = aws.String(fmt.Sprint(page + 1))
+	}
+	return out, nil
+}
+
+func (f *fakeAppRunner) DescribeService(ctx context.Context, in *apprunner.DescribeServiceInput, _ ...func(*apprunner.Options)) (*apprunner.DescribeServiceOutput, error) {
+	f.describes = append(f.describes, aws.ToString(in.ServiceArn))
+	for name, src := range f.sources {
+		if testAppRunnerArnPrefix+name == aws.ToString(in.ServiceArn) {
+			return &apprunner.DescribeServiceOutput{
+				Service: &apprunnerTypes.Service{ServiceArn: in.ServiceArn, SourceConfiguration: src},
+			}, nil
+		}
+	}
+	return nil, errors.New("service not found")
+}
+
+func TestScanAppRunnerServices(t *testing.T) {
+	image := func(id string) *apprunnerTypes.SourceConfiguration {
+		return &apprunnerTypes.SourceConfiguration{
+			ImageRepository: &apprunnerTypes.ImageRepository{ImageIdentifier: aws.String(id)},
+		}
+	}
+	client := &fakeAppRunner{
+		pages: [][]string{
+			{"web", "other"},
+			{"web-code", "web-public"},
+		},
+		sources: map[string]*apprunnerTypes.SourceConfiguration{
+			"web":   image(testECRHost + "/web:v1"),
+			"other": image(testECRHost + "/other:v1"),
+			"web-code": {
+				CodeRepository: &apprunnerTypes.CodeRepository{RepositoryUrl: aws.String("https://github.com/example/web")},
+			},
+			"web-public": image("public.ecr.aws/docker/library/nginx:latest"),
+		},
+	}
+	acs := []*ecrm.AppRunnerConfig{{NamePattern: "web*"}}
+	for _, ac := range acs {
+		if err := ac.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := ecrm.NewAppRunnerScanner(client)
+	if err := s.ScanAppRunnerServices(t.Context(), acs); err != nil {
+		t.Fatal(err)
+	}
+	// the services on all pages matching the config are described
+	expectedDescribes := []string{
+		testAppRunnerArnPrefix + "web",
+		testAppRunnerArnPrefix + "web-code",
+		testAppRunnerArnPrefix + "web-public",
+	}
+	if diff := cmp.Diff(expectedDescribes, client.describes); diff != "" {
+		t.Errorf("unexpected DescribeService calls (-want +got):\n%s", diff)
+	}
+	// only the ECR image of the image repository source is in use
+	u := ecrm.ImageURI(testECRHost + "/web:v1")
+	if len(s.Images) != 1 {
+		t.Errorf("unexpected images: %v", s.Images)
+	}
+	if diff := cmp.Diff([]string{testAppRunnerArnPrefix + "web"}, ecrm.ConsumersOf(s.Images, u)); diff != "" {
+		t.Errorf("unexpected consumers (-want +got):\n%s", diff)
+	}
+
+	// no services are listed without configs
+	client.describes = nil
+	if err := ecrm.NewAppRunnerScanner(client).ScanAppRunnerServices(t.Context(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.describes) != 0 {
+		t.Errorf("unexpected DescribeService calls: %v", client.describes)
+	}
+}
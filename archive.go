@@ -0,0 +1,43 @@
+package ecrm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/Songmu/prompter"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// archiveImages moves the images to the archive storage class.
+func (app *App) archiveImages(ctx context.Context, reg *registry, repo RepositoryName, ids []ecrTypes.ImageIdentifier, force bool) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if !force {
+		if !prompter.YN(fmt.Sprintf("Do you archive %d images on %s (%s)?", len(ids), repo, reg), false) {
+			return errors.New("aborted")
+		}
+	}
+	var archivedCount int
+	defer func() {
+		log.Printf("[info] Archived %d images on %s (%s)", archivedCount, repo, reg)
+	}()
+	for _, id := range ids {
+		log.Printf("[notice] Archiving %s %s (%s)", repo, aws.ToString(id.ImageDigest), reg)
+		out, err := reg.planner.ecr.UpdateImageStorageClass(ctx, &ecr.UpdateImageStorageClassInput{
+			ImageId:            &id,
+			RepositoryName:     aws.String(string(repo)),
+			TargetStorageClass: ecrTypes.TargetStorageClassArchive,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to archive %s@%s: %w", repo, aws.ToString(id.ImageDigest), err)
+		}
+		log.Printf("[debug] %s@%s is %s", repo, aws.ToString(id.ImageDigest), out.ImageStatus)
+		archivedCount++
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package ecrm
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/batch"
+	batchTypes "github.com/aws/aws-sdk-go-v2/service/batch/types"
+	"github.com/samber/lo"
+)
+
+// batchActiveJobStatuses are the statuses of jobs that use (or will use soon) the images.
+var batchActiveJobStatuses = []batchTypes.JobStatus{
+	batchTypes.JobStatusRunnable,
+	batchTypes.JobStatusStarting,
+	batchTypes.JobStatusRunning,
+}
+
+// scanBatchJobDefinitions collects images of the latest keep_count revisions of active job definitions.
+func (s *Scanner) scanBatchJobDefinitions(ctx context.Context, bcs []*BatchJobDefinitionConfig) error {
+	if len(bcs) == 0 {
+		return nil
+	}
+	revisions := make(map[string][]batchTypes.JobDefinition)
+	var names []string
+	p := batch.NewDescribeJobDefinitionsPaginator(s.batch, &batch.DescribeJobDefinitionsInput{
+		Status: aws.String("ACTIVE"),
+	})
+	for p.HasMorePages() {
+		r, err := p.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to describe Batch job definitions: %w", err)
+		}
+		for _, jd := range r.JobDefinitions {
+			name := aws.ToString(jd.JobDefinitionName)
+			if _, found := revisions[name]; !found {
+				names = append(names, name)
+			}
+			revisions[name] = append(revisions[name], jd)
+		}
+	}
+
+	for _, name := range names {
+		var keepCount int64
+		var matched bool
+		for _, bc := range bcs {
+			if bc.Match(name) {
+				matched = true
+				keepCount = bc.KeepCount
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		jds := revisions[name]
+		// newest first
+		slices.SortFunc(jds, func(a, b batchTypes.JobDefinition) int {
+			return cmp.Compare(aws.ToInt32(b.Revision), aws.ToInt32(a.Revision))
+		})
+		log.Printf("[debug] Checking Batch job definitions %s latest %d revisions", name, keepCount)
+		for _, jd := range jds[:min(int64(len(jds)), keepCount)] {
+			jdArn := aws.ToString(jd.JobDefinitionArn)
+			c := newConsumer(ConsumerTypeBatchJobDefinition, jdArn)
+			for _, u := range batchJobDefinitionImages(jd) {
+				if s.Images.AddConsumer(u, c) {
+					log.Printf("[info] %s is in use by Batch job definition %s", u.String(), jdArn)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// scanBatchJobQueues collects images of active jobs in the job queues.
+func (s *Scanner) scanBatchJobQueues(ctx context.Context, qcs []*BatchJobQueueConfig) error {
+	if len(qcs) == 0 {
+		return nil
+	}
+	p := batch.NewDescribeJobQueuesPaginator(s.batch, &batch.DescribeJobQueuesInput{})
+	for p.HasMorePages() {
+		r, err := p.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to describe Batch job queues: %w", err)
+		}
+		for _, q := range r.JobQueues {
+			name := aws.ToString(q.JobQueueName)
+			if !slices.ContainsFunc(qcs, func(qc *BatchJobQueueConfig) bool { return qc.Match(name) }) {
+				continue
+			}
+			if err := s.scanBatchJobQueue(ctx, aws.ToString(q.JobQueueArn)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Scanner) scanBatchJobQueue(ctx context.Context, queueArn string) error {
+	log.Printf("[debug] Checking jobs in Batch job queue %s", queueArn)
+	var jobIDs []string
+	for _, status := range batchActiveJobStatuses {
+		p := batch.NewListJobsPaginator(s.batch, &batch.ListJobsInput{
+			JobQueue:  &queueArn,
+			JobStatus: status,
+		})
+		for p.HasMorePages() {
+			r, err := p.NextPage(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to list jobs in %s: %w", queueArn, err)
+			}
+			for _, j := range r.JobSummaryList {
+				jobIDs = append(jobIDs, aws.ToString(j.JobId))
+			}
+		}
+	}
+	for _, ids := range lo.Chunk(jobIDs, 100) { // 100 is the max for DescribeJobs API
+		r, err := s.batch.DescribeJobs(ctx, &batch.DescribeJobsInput{Jobs: ids})
+		if err != nil {
+			return fmt.Errorf("failed to describe jobs in %s: %w", queueArn, err)
+		}
+		for _, job := range r.Jobs {
+			jobArn := aws.ToString(job.JobArn)
+			c := newConsumer(ConsumerTypeBatchJob, jobArn)
+			for _, u := range batchJobImages(job) {
+				if s.Images.AddConsumer(u, c) {
+					log.Printf("[info] %s is in use by Batch job %s (%s)", u.String(), jobArn, job.Status)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// batchJobDefinitionImages returns ECR images of the job definition.
+func batchJobDefinitionImages(jd batchTypes.JobDefinition) []ImageURI {
+	var images []*string
+	if jd.ContainerProperties != nil {
+		images = append(images, jd.ContainerProperties.Image)
+	}
+	images = append(images, batchEcsPropertiesImages(jd.EcsProperties)...)
+	images = append(images, batchEksPropertiesImages(jd.EksProperties)...)
+	images = append(images, batchNodePropertiesImages(jd.NodeProperties)...)
+	return ecrImageURIs(images)
+}
+
+// batchJobImages returns ECR images of the job.
+func batchJobImages(job batchTypes.JobDetail) []ImageURI {
+	var images []*string
+	if job.Container != nil {
+		images = append(images, job.Container.Image)
+	}
+	if job.EcsProperties != nil {
+		for _, tp := range job.EcsProperties.TaskProperties {
+			for _, cn := range tp.Containers {
+				images = append(images, cn.Image)
+			}
+		}
+	}
+	if job.EksProperties != nil && job.EksProperties.PodProperties != nil {
+		pp := job.EksProperties.PodProperties
+		for _, cn := range slices.Concat(pp.Containers, pp.InitContainers) {
+			images = append(images, cn.Image)
+		}
+	}
+	images = append(images, batchNodePropertiesImages(job.NodeProperties)...)
+	return ecrImageURIs(images)
+}
+
+func batchEcsPropertiesImages(ep *batchTypes.EcsProperties) []*string {
+	if ep == nil {
+		return nil
+	}
+	var images []*string
+	for _, tp := range ep.TaskProperties {
+		for _, cn := range tp.Containers {
+			images = append(images, cn.Image)
+		}
+	}
+	return images
+}
+
+func batchEksPropertiesImages(ep *batchTypes.EksProperties) []*string {
+	if ep == nil || ep.PodProperties == nil {
+		return nil
+	}
+	var images []*string
+	for _, cn := range slices.Concat(ep.PodProperties.Containers, ep.PodProperties.InitContainers) {
+		images = append(images, cn.Image)
+	}
+	return images
+}
+
+func batchNodePropertiesImages(np *batchTypes.NodeProperties) []*string {
+	if np == nil {
+		return nil
+	}
+	var images []*string
+	for _, nr := range np.NodeRangeProperties {
+		if nr.Container != nil {
+			images = append(images, nr.Container.Image)
+		}
+		images = append(images, batchEcsPropertiesImages(nr.EcsProperties)...)
+		images = append(images, batchEksPropertiesImages(nr.EksProperties)...)
+	}
+	return images
+}
+
+// ecrImageURIs returns unique ECR image URIs of the images.
+func ecrImageURIs(images []*string) []ImageURI {
+	var us []ImageURI
+	for _, image := range images {
+		u := ImageURI(aws.ToString(image))
+		if u == "" {
+			continue
+		}
+		if !u.IsECRImage() {
+			log.Printf("[debug] Skipping non ECR image %s", u)
+			continue
+		}
+		if !slices.Contains(us, u) {
+			us = append(us, u)
+		}
+	}
+	return us
+}
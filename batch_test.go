@@ -0,0 +1,58 @@
+package ecrm_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	batchTypes "github.com/aws/aws-sdk-go-v2/service/batch/types"
+	"github.com/fujiwara/ecrm"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBatchJobDefinitionImages(t *testing.T) {
+	jd := batchTypes.JobDefinition{
+		ContainerProperties: &batchTypes.ContainerProperties{
+			Image: aws.String(testECRHost + "/batch:v1"),
+		},
+		EcsProperties: &batchTypes.EcsProperties{
+			TaskProperties: []batchTypes.EcsTaskProperties{{
+				Containers: []batchTypes.TaskContainerProperties{
+					{Image: aws.String(testECRHost + "/batch:v1")},
+					{Image: aws.String(testECRHost + "/sidecar:v2")},
+					{Image: aws.String("public.ecr.aws/docker/library/busybox:latest")},
+				},
+			}},
+		},
+		NodeProperties: &batchTypes.NodeProperties{
+			NodeRangeProperties: []batchTypes.NodeRangeProperty{{
+				Container: &batchTypes.ContainerProperties{Image: aws.String(testECRHost + "/mpi:v3")},
+			}},
+		},
+	}
+	expected := []ecrm.ImageURI{
+		testECRHost + "/batch:v1",
+		testECRHost + "/sidecar:v2",
+		testECRHost + "/mpi:v3",
+	}
+	if diff := cmp.Diff(expected, ecrm.BatchJobDefinitionImages(jd)); diff != "" {
+		t.Errorf("unexpected images (-want +got):\n%s", diff)
+	}
+}
+
+func TestBatchJobImages(t *testing.T) {
+	job := batchTypes.JobDetail{
+		EksProperties: &batchTypes.EksPropertiesDetail{
+			PodProperties: &batchTypes.EksPodPropertiesDetail{
+				Containers:     []batchTypes.EksContainerDetail{{Image: aws.String(testECRHost + "/app:v1")}},
+				InitContainers: []batchTypes.EksContainerDetail{{Image: aws.String(testECRHost + "/init:v1")}},
+			},
+		},
+	}
+	expected := []ecrm.ImageURI{
+		testECRHost + "/app:v1",
+		testECRHost + "/init:v1",
+	}
+	if diff := cmp.Diff(expected, ecrm.BatchJobImages(job)); diff != "" {
+		t.Errorf("unexpected images (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,87 @@
+package ecrm
+
+import (
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// budgetProtectedReasons are the reasons of kept images that max_total_size never expires.
+var budgetProtectedReasons = []DecisionReason{
+	ReasonInUse,
+	ReasonKeepTagPattern,
+	ReasonRecentlyPulled,
+	ReasonImageIndexConstituent,
+}
+
+// budgetExpiry is an image expired by max_total_size.
+type budgetExpiry struct {
+	// index is the position of the image in the details.
+	index int
+	// parent is the image index that the image is expired with, if the image is a constituent.
+	parent string
+}
+
+// sizeBudget selects the oldest kept images that are not protected until the kept size fits in the budget.
+// details and decisions are aligned (images and image indexes). children is a map of image index digests to their child digests.
+// Constituent images are expired with their image index unless other kept image indexes reference them.
+// It returns the images to be expired and the kept size after expiring them.
+func sizeBudget(budget int64, details []ecrTypes.ImageDetail, decisions ImageDecisions, children map[string][]string) ([]budgetExpiry, int64) {
+	var kept int64
+	pos := make(map[string]int, len(decisions))
+	for i, dc := range decisions {
+		pos[dc.Digest] = i
+		if !dc.Expired {
+			kept += dc.Size
+		}
+	}
+	if kept <= budget {
+		return nil, kept
+	}
+
+	// number of kept image indexes referencing the child
+	refs := make(map[string]int)
+	var candidates []int
+	for i, dc := range decisions {
+		if dc.Expired {
+			continue
+		}
+		if isImageIndex(details[i]) {
+			for _, child := range children[dc.Digest] {
+				refs[child]++
+			}
+		}
+		if !slices.Contains(budgetProtectedReasons, dc.Reason) {
+			candidates = append(candidates, i)
+		}
+	}
+	// oldest first
+	slices.SortStableFunc(candidates, func(a, b int) int {
+		return aws.ToTime(details[a].ImagePushedAt).Compare(aws.ToTime(details[b].ImagePushedAt))
+	})
+
+	var expired []budgetExpiry
+	for _, i := range candidates {
+		if kept <= budget {
+			break
+		}
+		expired = append(expired, budgetExpiry{index: i})
+		kept -= decisions[i].Size
+		if !isImageIndex(details[i]) {
+			continue
+		}
+		for _, child := range children[decisions[i].Digest] {
+			refs[child]--
+			j, found := pos[child]
+			if !found || refs[child] > 0 {
+				continue
+			}
+			if c := decisions[j]; !c.Expired && c.Reason == ReasonImageIndexConstituent {
+				expired = append(expired, budgetExpiry{index: j, parent: decisions[i].Digest})
+				kept -= c.Size
+			}
+		}
+	}
+	return expired, kept
+}
@@ -0,0 +1,93 @@
+package ecrm_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/fujiwara/ecrm"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSizeBudget(t *testing.T) {
+	now := time.Now()
+	var details []ecrTypes.ImageDetail
+	var decisions ecrm.ImageDecisions
+	add := func(digest string, mediaType string, age time.Duration, size int64, expired bool, reason ecrm.DecisionReason) {
+		details = append(details, ecrTypes.ImageDetail{
+			ImageDigest:            aws.String(digest),
+			ImageManifestMediaType: aws.String(mediaType),
+			ImagePushedAt:          aws.Time(now.Add(-age)),
+			ImageSizeInBytes:       aws.Int64(size),
+		})
+		decisions = append(decisions, &ecrm.ImageDecision{Digest: digest, Size: size, Expired: expired, Reason: reason})
+	}
+	const (
+		image = "application/vnd.oci.image.manifest.v1+json"
+		index = "application/vnd.oci.image.index.v1+json"
+	)
+	hour := time.Hour
+	add("sha256:a", image, 1*hour, 10, false, ecrm.ReasonNotExpired)
+	add("sha256:b", image, 2*hour, 10, false, ecrm.ReasonInUse)
+	add("sha256:c", image, 9*hour, 10, false, ecrm.ReasonKeepTagPattern)
+	add("sha256:d", image, 4*hour, 10, false, ecrm.ReasonKeepCount)
+	add("sha256:e", image, 5*hour, 10, true, ecrm.ReasonExpired)
+	add("sha256:child1", image, 6*hour, 10, false, ecrm.ReasonImageIndexConstituent)
+	add("sha256:child2", image, 6*hour, 10, false, ecrm.ReasonImageIndexConstituent)
+	add("sha256:index1", index, 6*hour, 1, false, ecrm.ReasonNotExpired)
+	add("sha256:index2", index, 3*hour, 1, false, ecrm.ReasonInUse)
+	children := map[string][]string{
+		"sha256:index1": {"sha256:child1", "sha256:child2"},
+		"sha256:index2": {"sha256:child2"},
+	}
+
+	tests := []struct {
+		name    string
+		budget  int64
+		expired []int
+		kept    int64
+	}{
+		// kept: a,b,c,d,child1,child2,index1,index2 = 62
+		{"within budget", 62, nil, 62},
+		// index1 is the oldest, child1 is expired with it, child2 is kept by index2
+		{"expire an index", 60, []int{7, 5}, 51},
+		{"expire oldest first", 45, []int{7, 5, 3}, 41},
+		// b, c, child2 and index2 are protected
+		{"cannot meet", 10, []int{7, 5, 3, 0}, 31},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expired, kept := ecrm.SizeBudget(tt.budget, details, decisions, children)
+			if diff := cmp.Diff(tt.expired, expired); diff != "" {
+				t.Errorf("unexpected expired (-want +got):\n%s", diff)
+			}
+			if kept != tt.kept {
+				t.Errorf("unexpected kept size %d, want %d", kept, tt.kept)
+			}
+		})
+	}
+}
+
+func TestSummaryTableOverBudget(t *testing.T) {
+	sums := ecrm.NewRepoSummary("app")
+	sums.OverBudget(2 * 1000 * 1000 * 1000)
+	table := ecrm.SummaryTable(sums)
+
+	var b strings.Builder
+	if err := table.Print(&b, ecrm.FormatTable, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "OVER BUDGET") || !strings.Contains(b.String(), "2.0 GB") {
+		t.Errorf("over budget should be shown in the table:\n%s", b.String())
+	}
+
+	b.Reset()
+	if err := table.Print(&b, ecrm.FormatJSON, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `"over_budget_size": 2000000000`) {
+		t.Errorf("over budget should be shown in the JSON:\n%s", b.String())
+	}
+}
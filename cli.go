@@ -55,6 +55,10 @@ type CLI struct {
 	Scan     *ScanCLI     `cmd:"" help:"Scan ECS/Lambda resources. Output image URIs in use."`
 	Plan     *PlanCLI     `cmd:"" help:"Scan ECS/Lambda resources and find unused ECR images that can be deleted safely."`
 	Delete   *DeleteCLI   `cmd:"" help:"Scan ECS/Lambda resources and delete unused ECR images."`
+	Apply    *ApplyCLI    `cmd:"" help:"Delete ECR images exactly as planned in the plan file."`
+	Explain  *ExplainCLI  `cmd:"" help:"Explain why the images are kept or expired."`
+	Restore  *RestoreCLI  `cmd:"" help:"Remove quarantine tags from ECR images quarantined by soft_delete."`
+	Conf     *ConfigCLI   `cmd:"" name:"config" help:"Inspect the configuration file."`
 	Version  struct{}     `cmd:"" default:"1" help:"Show version."`
 
 	command string
@@ -70,6 +74,7 @@ func (c *GenerateCLI) Option() *Option {
 
 type PlanCLI struct {
 	PlanOrDelete
+	Out string `help:"Save the plan to FILE to apply later by the apply command." env:"ECRM_PLAN_OUT"`
 }
 
 func (c *PlanCLI) Option() *Option {
@@ -80,6 +85,8 @@ func (c *PlanCLI) Option() *Option {
 		ScannedFiles: c.ScannedFiles,
 		Delete:       false,
 		Repository:   RepositoryName(c.Repository),
+		PlanFile:     c.Out,
+		Detail:       c.Detail,
 	}
 }
 
@@ -97,6 +104,92 @@ func (c *DeleteCLI) Option() *Option {
 		Delete:       true,
 		Force:        c.Force,
 		Repository:   RepositoryName(c.Repository),
+		Detail:       c.Detail,
+	}
+}
+
+type ApplyCLI struct {
+	OutputCLI
+	PlanFile string `arg:"" help:"Plan file created by plan --out."`
+	Format   string `help:"Output format of plan(table, json)" default:"table" enum:"table,json" env:"ECRM_FORMAT"`
+	Force    bool   `help:"force delete images without confirmation" env:"ECRM_FORCE"`
+}
+
+func (c *ApplyCLI) Option() *Option {
+	return &Option{
+		OutputFile: c.Output,
+		Format:     newOutputFormatFrom(c.Format),
+		Delete:     true,
+		Apply:      true,
+		Force:      c.Force,
+		PlanFile:   c.PlanFile,
+	}
+}
+
+type ExplainCLI struct {
+	OutputCLI
+	Images       []string `arg:"" help:"Image URIs or digests to explain."`
+	Format       string   `help:"Output format of explanation(table, json)" default:"table" enum:"table,json" env:"ECRM_FORMAT"`
+	Scan         bool     `help:"Scan ECS/Lambda resources that in use." default:"true" negatable:"" env:"ECRM_SCAN"`
+	ScannedFiles []string `help:"Files of the scan result. ecrm does not delete images in these files." env:"ECRM_SCANNED_FILES"`
+}
+
+func (c *ExplainCLI) Option() *Option {
+	return &Option{
+		OutputFile:    c.Output,
+		Format:        newOutputFormatFrom(c.Format),
+		Scan:          c.Scan,
+		ScannedFiles:  c.ScannedFiles,
+		ExplainImages: c.Images,
+	}
+}
+
+type RestoreCLI struct {
+	Repository string `help:"Restore images in the repository only." short:"r" env:"ECRM_REPOSITORY"`
+	Force      bool   `help:"force restore images without confirmation" env:"ECRM_FORCE"`
+}
+
+func (c *RestoreCLI) Option() *Option {
+	return &Option{
+		Repository: RepositoryName(c.Repository),
+		Force:      c.Force,
+	}
+}
+
+type ConfigCLI struct {
+	ShowEffective *ShowEffectiveCLI  `cmd:"" help:"Show the effective repositories rule for the repository."`
+	Validate      *ConfigValidateCLI `cmd:"" help:"Validate the configuration file and lint rules."`
+}
+
+type ConfigValidateCLI struct {
+	OutputCLI
+	Format        string `help:"Output format of issues(table, json, github)" default:"table" enum:"table,json,github" env:"ECRM_FORMAT"`
+	Resources     string `help:"JSON file of existing resource names to find patterns matching nothing." env:"ECRM_RESOURCES"`
+	Live          bool   `help:"Find patterns matching nothing by existing resources from AWS APIs." env:"ECRM_LIVE"`
+	MinExpires    string `help:"Warn expires shorter than the duration (e.g. a deployment cycle). The default is ${min_expires}." env:"ECRM_MIN_EXPIRES"`
+	FailOnWarning bool   `help:"Exit with an error on warnings." env:"ECRM_FAIL_ON_WARNING"`
+}
+
+func (c *ConfigValidateCLI) Option() *Option {
+	return &Option{
+		OutputFile:    c.Output,
+		Format:        newOutputFormatFrom(c.Format),
+		ResourcesFile: c.Resources,
+		Live:          c.Live,
+		MinExpires:    c.MinExpires,
+		FailOnWarning: c.FailOnWarning,
+	}
+}
+
+type ShowEffectiveCLI struct {
+	Repository string `arg:"" help:"Repository name."`
+	Registry   string `help:"Registry name defined in registries." env:"ECRM_REGISTRY"`
+}
+
+func (c *ShowEffectiveCLI) Option() *Option {
+	return &Option{
+		Repository: RepositoryName(c.Repository),
+		Registry:   c.Registry,
 	}
 }
 
@@ -106,6 +199,7 @@ type PlanOrDelete struct {
 	Scan         bool     `help:"Scan ECS/Lambda resources that in use." default:"true" negatable:"" env:"ECRM_SCAN"`
 	Repository   string   `help:"Manage images in the repository only." short:"r" env:"ECRM_REPOSITORY"`
 	ScannedFiles []string `help:"Files of the scan result. ecrm does not delete images in these files." env:"ECRM_SCANNED_FILES"`
+	Detail       bool     `help:"Output decisions for each image instead of summaries." env:"ECRM_DETAIL"`
 }
 
 type OutputCLI struct {
@@ -114,19 +208,21 @@ type OutputCLI struct {
 
 type ScanCLI struct {
 	OutputCLI
+	FormatVersion int `help:"Version of the output format. 1 is a legacy JSON array of image URIs, 2 preserves consumers of each image URI." default:"1" enum:"1,2" env:"ECRM_SCAN_FORMAT_VERSION"`
 }
 
 func (c *ScanCLI) Option() *Option {
 	return &Option{
-		OutputFile: c.Output,
-		Scan:       true,
-		ScanOnly:   true,
+		OutputFile:        c.Output,
+		Scan:              true,
+		ScanOnly:          true,
+		ScanFormatVersion: c.FormatVersion,
 	}
 }
 
 func (app *App) NewCLI() *CLI {
 	c := &CLI{}
-	k := kong.Parse(c)
+	k := kong.Parse(c, kong.Vars{"min_expires": defaultMinExpires})
 	c.command = k.Command()
 	c.app = app
 	return c
@@ -146,6 +242,16 @@ func (c *CLI) Run(ctx context.Context) error {
 		return c.app.Run(ctx, c.Config, c.Plan.Option())
 	case "delete":
 		return c.app.Run(ctx, c.Config, c.Delete.Option())
+	case "apply <plan-file>":
+		return c.app.Apply(ctx, c.Config, c.Apply.Option())
+	case "explain <images>":
+		return c.app.Explain(ctx, c.Config, c.Explain.Option())
+	case "restore":
+		return c.app.Restore(ctx, c.Config, c.Restore.Option())
+	case "config show-effective <repository>":
+		return c.app.ShowEffectiveConfig(ctx, c.Config, c.Conf.ShowEffective.Option())
+	case "config validate":
+		return c.app.ValidateConfig(ctx, c.Config, c.Conf.Validate.Option())
 	case "version":
 		fmt.Printf("ecrm version %s\n", c.app.Version)
 		if !c.ShowVersion {
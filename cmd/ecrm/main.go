@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"os"
 	"strings"
@@ -28,6 +29,12 @@ func main() {
 	}
 	if err := cli.Run(ctx); err != nil {
 		log.Println("[error]", err)
+		// match the concrete type, not any ExitCode() method, because errors of
+		// external commands wrap *exec.ExitError that has ExitCode() too.
+		var failures ecrm.DeleteFailures
+		if errors.As(err, &failures) {
+			os.Exit(failures.ExitCode())
+		}
 		os.Exit(1)
 	}
 }
@@ -1,16 +1,21 @@
 package ecrm
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
-	"github.com/fujiwara/ecrm/wildcard"
+	"github.com/dustin/go-humanize"
 	"github.com/goccy/go-yaml"
 	"github.com/k1LoW/duration"
+	"github.com/samber/lo"
 )
 
 var (
@@ -20,20 +25,53 @@ var (
 )
 
 type Config struct {
-	Clusters         []*ClusterConfig    `yaml:"clusters"`
-	TaskDefinitions  []*TaskdefConfig    `yaml:"task_definitions"`
-	LambdaFunctions  []*LambdaConfig     `yaml:"lambda_functions"`
-	ExternalCommands []*ExternalCommand  `yaml:"external_commands"`
-	Repositories     []*RepositoryConfig `yaml:"repositories"`
+	Targets             []*TargetConfig             `yaml:"targets"`
+	Registries          []*TargetConfig             `yaml:"registries"`
+	Clusters            []*ClusterConfig            `yaml:"clusters"`
+	TaskDefinitions     []*TaskdefConfig            `yaml:"task_definitions"`
+	LambdaFunctions     []*LambdaConfig             `yaml:"lambda_functions"`
+	AppRunnerServices   []*AppRunnerConfig          `yaml:"app_runner_services,omitempty"`
+	BatchJobDefinitions []*BatchJobDefinitionConfig `yaml:"batch_job_definitions,omitempty"`
+	BatchJobQueues      []*BatchJobQueueConfig      `yaml:"batch_job_queues,omitempty"`
+	ExternalCommands    []*ExternalCommand          `yaml:"external_commands"`
+	Kubernetes          []*KubernetesConfig         `yaml:"kubernetes,omitempty"`
+	Defaults            *RepositoryConfig           `yaml:"defaults,omitempty"`
+	Repositories        []*RepositoryConfig         `yaml:"repositories"`
+	MaxDeleteCount      int64                       `yaml:"max_delete_count,omitempty"`
+	MaxDeleteRatio      float64                     `yaml:"max_delete_ratio,omitempty"`
+	SoftDelete          *SoftDeleteConfig           `yaml:"soft_delete,omitempty"`
+	SkipScheduledTasks  bool                        `yaml:"skip_scheduled_tasks,omitempty"`
+
+	hash string
+}
+
+// Hash returns the SHA-256 hash of the loaded configuration file.
+func (c *Config) Hash() string {
+	return c.hash
 }
 
+// Validate validates the config and returns all the validation errors joined.
 func (c *Config) Validate() error {
+	var errs []error
+	if err := validateDeleteLimit(c.MaxDeleteCount, c.MaxDeleteRatio); err != nil {
+		errs = append(errs, err)
+	}
+	if c.SoftDelete != nil {
+		if err := c.SoftDelete.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, tc := range c.Targets {
+		if err := tc.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
 	if c.Clusters == nil {
 		log.Println("[warn] clusters are not defined. No ECS clusters will be scanned to find images now using.")
 	}
 	for _, cc := range c.Clusters {
 		if err := cc.Validate(); err != nil {
-			return err
+			errs = append(errs, err)
 		}
 	}
 
@@ -42,7 +80,7 @@ func (c *Config) Validate() error {
 	}
 	for _, tc := range c.TaskDefinitions {
 		if err := tc.Validate(); err != nil {
-			return err
+			errs = append(errs, err)
 		}
 	}
 
@@ -51,26 +89,102 @@ func (c *Config) Validate() error {
 	}
 	for _, lc := range c.LambdaFunctions {
 		if err := lc.Validate(); err != nil {
-			return err
+			errs = append(errs, err)
 		}
 	}
-	for _, rc := range c.Repositories {
+	for _, bc := range c.BatchJobDefinitions {
+		if err := bc.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, qc := range c.BatchJobQueues {
+		if err := qc.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, ac := range c.AppRunnerServices {
+		if err := ac.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, kc := range c.Kubernetes {
+		if err := kc.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	registryNames := newSet()
+	for _, rc := range c.Registries {
 		if err := rc.Validate(); err != nil {
-			return err
+			errs = append(errs, err)
+		}
+		if rc.Name == "" {
+			errs = append(errs, fmt.Errorf("registry %s name is required", rc))
+		} else if !registryNames.add(rc.Name) {
+			errs = append(errs, fmt.Errorf("registry name %s is duplicated", rc.Name))
 		}
 	}
-	return nil
+	if c.Defaults != nil {
+		if err := c.Defaults.validateDefaults(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, rc := range c.Repositories {
+		if err := rc.validate(c.Defaults); err != nil {
+			errs = append(errs, err)
+		}
+		for _, name := range rc.Registries {
+			if !registryNames.contains(name) {
+				errs = append(errs, fmt.Errorf("repository %s registry %s is not defined in registries", rc, name))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// repositoriesFor returns the repositories rules for the registry, with the defaults at first.
+func (c *Config) repositoriesFor(registryName string) []*RepositoryConfig {
+	rcs := make([]*RepositoryConfig, 0, len(c.Repositories)+1)
+	if c.Defaults != nil {
+		rcs = append(rcs, c.Defaults)
+	}
+	for _, rc := range c.Repositories {
+		if len(rc.Registries) == 0 || slices.Contains(rc.Registries, registryName) {
+			rcs = append(rcs, rc)
+		}
+	}
+	return rcs
+}
+
+// hasRegistry reports whether the registry is defined in registries.
+func (c *Config) hasRegistry(name string) bool {
+	return slices.ContainsFunc(c.Registries, func(t *TargetConfig) bool {
+		return t.Name == name
+	})
+}
+
+// allRepositories returns all the repositories rules regardless of registries, with the defaults at first.
+func (c *Config) allRepositories() []*RepositoryConfig {
+	if c.Defaults == nil {
+		return c.Repositories
+	}
+	return append([]*RepositoryConfig{c.Defaults}, c.Repositories...)
 }
 
 type ClusterConfig struct {
 	Name        string `yaml:"name,omitempty"`
 	NamePattern string `yaml:"name_pattern,omitempty"`
+
+	namePattern *pattern
 }
 
 func (c *ClusterConfig) Validate() error {
 	if c.Name == "" && c.NamePattern == "" {
 		return errors.New("cluster name or name_pattern is required")
 	}
+	var err error
+	if c.namePattern, err = compileNamePattern(c.NamePattern); err != nil {
+		return fmt.Errorf("cluster %w", err)
+	}
 	return nil
 }
 
@@ -79,22 +193,229 @@ func (c *ClusterConfig) Match(name string) bool {
 	if c.Name == name {
 		return true
 	}
-	return wildcard.Match(c.NamePattern, name)
+	return matchPattern(c.namePattern, c.NamePattern, name)
+}
+
+// compileNamePattern compiles name_pattern. It returns nil if name_pattern is empty.
+func compileNamePattern(s string) (*pattern, error) {
+	if s == "" {
+		return nil, nil
+	}
+	p, err := compilePattern(s)
+	if err != nil {
+		return nil, fmt.Errorf("name_pattern: %w", err)
+	}
+	return p, nil
 }
 
 type RepositoryName string
 
 type RepositoryConfig struct {
-	Name            RepositoryName `yaml:"name,omitempty"`
-	NamePattern     string         `yaml:"name_pattern,omitempty"`
-	Expires         string         `yaml:"expires,omitempty"`
-	KeepCount       int64          `yaml:"keep_count,omitempty"`
-	KeepTagPatterns []string       `yaml:"keep_tag_patterns,omitempty"`
+	Name            RepositoryName    `yaml:"name,omitempty"`
+	NamePattern     string            `yaml:"name_pattern,omitempty"`
+	Expires         string            `yaml:"expires,omitempty"`
+	KeepCount       int64             `yaml:"keep_count,omitempty"`
+	KeepTagPatterns []string          `yaml:"keep_tag_patterns,omitempty"`
+	Registries      []string          `yaml:"registries,omitempty"`
+	MaxDeleteCount  int64             `yaml:"max_delete_count,omitempty"`
+	MaxDeleteRatio  float64           `yaml:"max_delete_ratio,omitempty"`
+	Action          string            `yaml:"action,omitempty"`
+	DeleteAfter     string            `yaml:"delete_after,omitempty"`
+	PulledWithin    string            `yaml:"pulled_within,omitempty"`
+	KeepSemver      *KeepSemverConfig `yaml:"keep_semver,omitempty"`
+	Streams         *StreamsConfig    `yaml:"streams,omitempty"`
+	Untagged        *UntaggedConfig   `yaml:"untagged,omitempty"`
+	MaxTotalSize    string            `yaml:"max_total_size,omitempty"`
+	Stop            bool              `yaml:"stop,omitempty"`
 
-	expireBefore time.Time
+	ExpireTagPatterns []*ExpireTagPattern `yaml:"expire_tag_patterns,omitempty"`
+
+	expireBefore      time.Time
+	deleteAfterBefore time.Time
+	pulledAfter       time.Time
+	maxTotalSize      int64
+	namePattern       *pattern
+	keepTagPatterns   patterns
+
+	// isDefaults reports whether the config is the defaults of the repositories rules.
+	isDefaults bool
+	// layers are the rules merged into the effective config.
+	layers []string
+	// keys are the keys written in YAML. A field with a zero value overrides the inherited value if its key is written.
+	keys set
+}
+
+func (r *RepositoryConfig) UnmarshalYAML(b []byte) error {
+	type plain RepositoryConfig
+	if err := yaml.Unmarshal(b, (*plain)(r)); err != nil {
+		return err
+	}
+	var keys map[string]any
+	if err := yaml.Unmarshal(b, &keys); err != nil {
+		return err
+	}
+	r.keys = newSet()
+	for k := range keys {
+		r.keys.add(k)
+	}
+	return nil
+}
+
+// isSet reports whether the field of the key is set in the rule.
+func (r *RepositoryConfig) isSet(key string, zero bool) bool {
+	return !zero || r.keys.contains(key)
+}
+
+const (
+	ActionDelete  = "delete"
+	ActionArchive = "archive"
+)
+
+// matchRepositoryConfig returns the effective RepositoryConfig for the repository name, or nil if no rules match.
+//
+// The matched rules are layered in order. The first matched rule has the highest precedence,
+// and the fields not set in it are inherited from the following matched rules and the defaults.
+// A rule with stop stops inheriting from the following rules.
+func matchRepositoryConfig(rcs []*RepositoryConfig, name RepositoryName) *RepositoryConfig {
+	var effective, defaults *RepositoryConfig
+	var ownKeepTagPatterns bool
+	for _, rc := range rcs {
+		if rc.isDefaults {
+			defaults = rc
+			continue
+		}
+		if !rc.MatchName(name) {
+			continue
+		}
+		if effective == nil {
+			e := *rc
+			e.layers = nil
+			effective = &e
+			ownKeepTagPatterns = rc.isSet("keep_tag_patterns", len(rc.KeepTagPatterns) == 0)
+		} else {
+			effective.inherit(rc)
+		}
+		effective.layers = append(effective.layers, rc.String())
+		if rc.Stop {
+			break
+		}
+	}
+	if effective == nil {
+		return nil
+	}
+	if defaults != nil {
+		effective.inherit(defaults)
+		effective.layers = append(effective.layers, defaults.String())
+	}
+	if len(effective.KeepTagPatterns) == 0 {
+		effective.KeepTagPatterns = DefaultKeepTagPatterns
+	} else if !ownKeepTagPatterns {
+		// the first matched rule keeps the default keep_tag_patterns unless it sets them
+		effective.addDefaultKeepTagPatterns()
+	}
+	if effective.Action == "" {
+		effective.Action = ActionDelete
+	}
+	return effective
+}
+
+// addDefaultKeepTagPatterns adds DefaultKeepTagPatterns to the inherited keep_tag_patterns.
+// Patterns with only negated ones keep all the tags not excluded, so they are left as is.
+func (r *RepositoryConfig) addDefaultKeepTagPatterns() {
+	var hasPositive bool
+	for _, p := range r.KeepTagPatterns {
+		if !strings.HasPrefix(p, PatternNegationPrefix) {
+			hasPositive = true
+			break
+		}
+	}
+	if !hasPositive {
+		return
+	}
+	ps := slices.Clone(r.KeepTagPatterns)
+	for _, p := range DefaultKeepTagPatterns {
+		if !slices.Contains(ps, p) {
+			ps = append(ps, p)
+		}
+	}
+	r.KeepTagPatterns = ps
+	r.keepTagPatterns, _ = compilePatterns(ps)
+}
+
+// inherit sets the fields not set in r from base.
+// expires and action cannot be unset, so their zero values are always inherited.
+func (r *RepositoryConfig) inherit(base *RepositoryConfig) {
+	if r.Expires == "" {
+		r.Expires, r.expireBefore = base.Expires, base.expireBefore
+	}
+	if !r.isSet("keep_count", r.KeepCount == 0) {
+		r.KeepCount = base.KeepCount
+	}
+	if !r.isSet("keep_tag_patterns", len(r.KeepTagPatterns) == 0) {
+		r.KeepTagPatterns, r.keepTagPatterns = base.KeepTagPatterns, base.keepTagPatterns
+	}
+	if !r.isSet("max_delete_count", r.MaxDeleteCount == 0) {
+		r.MaxDeleteCount = base.MaxDeleteCount
+	}
+	if !r.isSet("max_delete_ratio", r.MaxDeleteRatio == 0) {
+		r.MaxDeleteRatio = base.MaxDeleteRatio
+	}
+	if r.Action == "" {
+		r.Action = base.Action
+	}
+	if !r.isSet("delete_after", r.DeleteAfter == "") {
+		r.DeleteAfter, r.deleteAfterBefore = base.DeleteAfter, base.deleteAfterBefore
+	}
+	if !r.isSet("pulled_within", r.PulledWithin == "") {
+		r.PulledWithin, r.pulledAfter = base.PulledWithin, base.pulledAfter
+	}
+	if !r.isSet("keep_semver", r.KeepSemver == nil) {
+		r.KeepSemver = base.KeepSemver
+	}
+	if !r.isSet("streams", r.Streams == nil) {
+		r.Streams = base.Streams
+	}
+	if !r.isSet("untagged", r.Untagged == nil) {
+		r.Untagged = base.Untagged
+	}
+	if !r.isSet("max_total_size", r.MaxTotalSize == "") {
+		r.MaxTotalSize, r.maxTotalSize = base.MaxTotalSize, base.maxTotalSize
+	}
+	if !r.isSet("expire_tag_patterns", len(r.ExpireTagPatterns) == 0) {
+		r.ExpireTagPatterns = base.ExpireTagPatterns
+	}
+	// the inherited fields are set for the following rules
+	r.keys = r.keys.union(base.keys)
+}
+
+// printEffective prints the effective config for the repository in YAML.
+func (r *RepositoryConfig) printEffective(w io.Writer, name RepositoryName) error {
+	e := *r
+	e.Name, e.NamePattern, e.Registries, e.Stop = name, "", nil, false
+	b, err := yaml.Marshal(&e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+	fmt.Fprintf(w, "# rules: %s\n", strings.Join(r.layers, ", "))
+	_, err = w.Write(b)
+	return err
 }
 
 func (r *RepositoryConfig) Validate() error {
+	return r.validate(nil)
+}
+
+// validateDefaults validates the defaults of the repositories rules.
+func (r *RepositoryConfig) validateDefaults() error {
+	if r.Name != "" || r.NamePattern != "" || len(r.Registries) > 0 || r.Stop {
+		return errors.New("defaults cannot have name, name_pattern, registries and stop")
+	}
+	r.isDefaults = true
+	return r.validate(nil)
+}
+
+// validate validates the rule. The fields not set in the rule may be set by the defaults.
+func (r *RepositoryConfig) validate(defaults *RepositoryConfig) error {
 	now := time.Now()
 	if r.Name != "" && r.NamePattern != "" {
 		return errors.New("repositories name and name_pattern are exclusive")
@@ -105,42 +426,192 @@ func (r *RepositoryConfig) Validate() error {
 		} else {
 			r.expireBefore = now.Add(-d)
 		}
-	} else {
+	} else if !r.isDefaults && (defaults == nil || defaults.Expires == "") {
 		return fmt.Errorf("repository %s%s expires is required", r.Name, r.NamePattern)
 	}
+	if err := validateDeleteLimit(r.MaxDeleteCount, r.MaxDeleteRatio); err != nil {
+		return fmt.Errorf("repository %s: %w", r, err)
+	}
+	action := r.Action
+	switch action {
+	case "":
+		if defaults != nil {
+			action = defaults.Action
+		}
+	case ActionDelete, ActionArchive:
+	default:
+		return fmt.Errorf("repository %s action must be %s or %s", r, ActionDelete, ActionArchive)
+	}
+	if r.DeleteAfter != "" {
+		if action != ActionArchive && !r.isDefaults {
+			return fmt.Errorf("repository %s delete_after requires action %s", r, ActionArchive)
+		}
+		d, err := duration.Parse(r.DeleteAfter)
+		if err != nil {
+			return fmt.Errorf("repository %s invalid delete_after: %w", r, err)
+		}
+		r.deleteAfterBefore = now.Add(-d)
+	}
+	if r.PulledWithin != "" {
+		d, err := duration.Parse(r.PulledWithin)
+		if err != nil {
+			return fmt.Errorf("repository %s invalid pulled_within: %w", r, err)
+		}
+		r.pulledAfter = now.Add(-d)
+	}
+	if r.KeepSemver != nil {
+		if err := r.KeepSemver.Validate(); err != nil {
+			return fmt.Errorf("repository %s: %w", r, err)
+		}
+	}
+	if r.Streams != nil {
+		if err := r.Streams.Validate(); err != nil {
+			return fmt.Errorf("repository %s: %w", r, err)
+		}
+	}
+	if r.MaxTotalSize != "" {
+		size, err := humanize.ParseBytes(r.MaxTotalSize)
+		if err != nil {
+			return fmt.Errorf("repository %s invalid max_total_size: %w", r, err)
+		}
+		r.maxTotalSize = int64(size)
+	}
+	if r.Untagged != nil {
+		if err := r.Untagged.Validate(); err != nil {
+			return fmt.Errorf("repository %s: %w", r, err)
+		}
+	}
+	for _, et := range r.ExpireTagPatterns {
+		if err := et.Validate(); err != nil {
+			return fmt.Errorf("repository %s: %w", r, err)
+		}
+	}
 
-	if len(r.KeepTagPatterns) == 0 {
-		log.Printf(
-			"[warn] keep_tag_patterns are not defined. set default keep_tag_patterns to %v",
-			DefaultKeepTagPatterns,
-		)
-		r.KeepTagPatterns = DefaultKeepTagPatterns
+	keepTagPatterns := r.KeepTagPatterns
+	if len(keepTagPatterns) == 0 {
+		if !r.isDefaults && (defaults == nil || len(defaults.KeepTagPatterns) == 0) {
+			log.Printf(
+				"[warn] keep_tag_patterns are not defined. set default keep_tag_patterns to %v",
+				DefaultKeepTagPatterns,
+			)
+		}
+		keepTagPatterns = DefaultKeepTagPatterns
+	}
+	var err error
+	if r.namePattern, err = compileNamePattern(r.NamePattern); err != nil {
+		return fmt.Errorf("repository %s %w", r, err)
+	}
+	if r.keepTagPatterns, err = compilePatterns(keepTagPatterns); err != nil {
+		return fmt.Errorf("repository %s keep_tag_patterns: %w", r, err)
 	}
 
 	return nil
 }
 
+func (r *RepositoryConfig) String() string {
+	if len(r.layers) > 1 {
+		return strings.Join(r.layers, ", ")
+	}
+	if r.isDefaults {
+		return "defaults"
+	}
+	if r.Name != "" {
+		return fmt.Sprintf("name=%s", r.Name)
+	}
+	return fmt.Sprintf("name_pattern=%s", r.NamePattern)
+}
+
 func (r *RepositoryConfig) MatchName(name RepositoryName) bool {
 	if r.Name == name {
 		return true
 	}
-	return wildcard.Match(r.NamePattern, string(name))
+	return matchPattern(r.namePattern, r.NamePattern, string(name))
 }
 
 func (r *RepositoryConfig) MatchTag(tag string) bool {
-	for _, pattern := range r.KeepTagPatterns {
-		if wildcard.Match(pattern, tag) {
-			return true
+	_, matched := r.matchedTagPattern(tag)
+	return matched
+}
+
+// matchedTagPattern returns the first keep_tag_patterns that matches the tag.
+func (r *RepositoryConfig) matchedTagPattern(tag string) (string, bool) {
+	ps := r.keepTagPatterns
+	if ps == nil {
+		// not validated yet
+		ps, _ = compilePatterns(r.KeepTagPatterns)
+	}
+	if p, matched := ps.match(tag); matched {
+		return p.String(), true
+	}
+	return "", false
+}
+
+// matchedTags returns the first tag and keep_tag_patterns that match.
+func (r *RepositoryConfig) matchedTags(tags []string) (string, string, bool) {
+	for _, tag := range tags {
+		if pattern, matched := r.matchedTagPattern(tag); matched {
+			return tag, pattern, true
 		}
 	}
-	return false
+	return "", "", false
+}
+
+// matchedExpireTagPattern returns the expire_tag_patterns that all the tags match.
+// When the tags match multiple patterns, the pattern with the longest expires is returned.
+// Untagged images never match.
+func (r *RepositoryConfig) matchedExpireTagPattern(tags []string) (*ExpireTagPattern, bool) {
+	if len(tags) == 0 || len(r.ExpireTagPatterns) == 0 {
+		return nil, false
+	}
+	var matched *ExpireTagPattern
+	for _, tag := range tags {
+		et, found := lo.Find(r.ExpireTagPatterns, func(et *ExpireTagPattern) bool {
+			return matchPattern(et.pattern, et.Pattern, tag)
+		})
+		if !found {
+			return nil, false
+		}
+		if matched == nil || et.expireBefore.Before(matched.expireBefore) {
+			matched = et
+		}
+	}
+	return matched, true
 }
 
 func (r *RepositoryConfig) IsExpired(at time.Time) bool {
 	return at.Before(r.expireBefore)
 }
 
+// IsRecentlyPulled reports whether the image last pulled at the time is kept by pulled_within.
+// ECR does not record the pull time of images that have never been pulled (zero time).
+func (r *RepositoryConfig) IsRecentlyPulled(pulledAt time.Time) bool {
+	if r.PulledWithin == "" || pulledAt.IsZero() {
+		return false
+	}
+	return !pulledAt.Before(r.pulledAfter)
+}
+
+// IsArchiveExpired reports whether the image archived at the time should be deleted by delete_after.
+func (r *RepositoryConfig) IsArchiveExpired(archivedAt time.Time) bool {
+	if r.DeleteAfter == "" {
+		return false
+	}
+	return archivedAt.Before(r.deleteAfterBefore)
+}
+
 func LoadConfig(path string) (*Config, error) {
+	c, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readConfig reads and parses the config file without validation.
+func readConfig(path string) (*Config, error) {
 	log.Println("[info] loading config file:", path)
 	f, err := os.Open(path)
 	if err != nil {
@@ -151,25 +622,63 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, err
 	}
 	c := &Config{}
+	sum := sha256.Sum256(b)
+	c.hash = hex.EncodeToString(sum[:])
 	if err := yaml.Unmarshal(b, c); err != nil {
 		return nil, err
 	}
-	if err := c.Validate(); err != nil {
-		return nil, err
-	}
 	return c, nil
 }
 
+// ExpireTagPattern expires images whose tags match the pattern after its own expires.
+type ExpireTagPattern struct {
+	Pattern string `yaml:"pattern"`
+	Expires string `yaml:"expires"`
+
+	pattern      *pattern
+	expireBefore time.Time
+}
+
+func (e *ExpireTagPattern) Validate() error {
+	if e.Pattern == "" {
+		return errors.New("expire_tag_patterns pattern is required")
+	}
+	if e.Expires == "" {
+		return fmt.Errorf("expire_tag_patterns %s expires is required", e.Pattern)
+	}
+	p, err := compilePattern(e.Pattern)
+	if err != nil {
+		return fmt.Errorf("expire_tag_patterns: %w", err)
+	}
+	e.pattern = p
+	d, err := duration.Parse(e.Expires)
+	if err != nil {
+		return fmt.Errorf("expire_tag_patterns %s invalid expires: %w", e.Pattern, err)
+	}
+	e.expireBefore = time.Now().Add(-d)
+	return nil
+}
+
+func (e *ExpireTagPattern) IsExpired(at time.Time) bool {
+	return at.Before(e.expireBefore)
+}
+
 type TaskdefConfig struct {
 	Name        string `yaml:"name,omitempty"`
 	NamePattern string `yaml:"name_pattern,omitempty"`
 	KeepCount   int64  `yaml:"keep_count,omitempty"`
+
+	namePattern *pattern
 }
 
 func (c *TaskdefConfig) Validate() error {
 	if c.Name != "" && c.NamePattern != "" {
 		return errors.New("task_definitions name and name_pattern are exclusive")
 	}
+	var err error
+	if c.namePattern, err = compileNamePattern(c.NamePattern); err != nil {
+		return fmt.Errorf("task_definitions %w", err)
+	}
 
 	if c.KeepCount == 0 {
 		log.Printf(
@@ -187,7 +696,7 @@ func (c *TaskdefConfig) Match(name string) bool {
 	if c.Name == name {
 		return true
 	}
-	return wildcard.Match(c.NamePattern, name)
+	return matchPattern(c.namePattern, c.NamePattern, name)
 }
 
 type LambdaConfig struct {
@@ -195,12 +704,18 @@ type LambdaConfig struct {
 	NamePattern string `yaml:"name_pattern,omitempty"`
 	KeepCount   int64  `yaml:"keep_count,omitempty"`
 	KeepAliase  *bool  `yaml:"keep_aliase,omitempty"` // for backward compatibility
+
+	namePattern *pattern
 }
 
 func (c *LambdaConfig) Validate() error {
 	if c.Name != "" && c.NamePattern != "" {
 		return errors.New("lambda_functions name and name_pattern are exclusive")
 	}
+	var err error
+	if c.namePattern, err = compileNamePattern(c.NamePattern); err != nil {
+		return fmt.Errorf("lambda_functions %w", err)
+	}
 	if c.KeepCount == 0 {
 		log.Printf(
 			"[warn] keep_count for lambda_functions %s%s is not defined. Using default keep_count=%d",
@@ -222,5 +737,93 @@ func (c *LambdaConfig) Match(name string) bool {
 	if c.Name == name {
 		return true
 	}
-	return wildcard.Match(c.NamePattern, name)
+	return matchPattern(c.namePattern, c.NamePattern, name)
+}
+
+type AppRunnerConfig struct {
+	Name        string `yaml:"name,omitempty"`
+	NamePattern string `yaml:"name_pattern,omitempty"`
+
+	namePattern *pattern
+}
+
+func (c *AppRunnerConfig) Validate() error {
+	if c.Name == "" && c.NamePattern == "" {
+		return errors.New("app_runner_services name or name_pattern is required")
+	}
+	if c.Name != "" && c.NamePattern != "" {
+		return errors.New("app_runner_services name and name_pattern are exclusive")
+	}
+	var err error
+	if c.namePattern, err = compileNamePattern(c.NamePattern); err != nil {
+		return fmt.Errorf("app_runner_services %w", err)
+	}
+	return nil
+}
+
+func (c *AppRunnerConfig) Match(name string) bool {
+	if c.Name == name {
+		return true
+	}
+	return matchPattern(c.namePattern, c.NamePattern, name)
+}
+
+type BatchJobDefinitionConfig struct {
+	Name        string `yaml:"name,omitempty"`
+	NamePattern string `yaml:"name_pattern,omitempty"`
+	KeepCount   int64  `yaml:"keep_count,omitempty"`
+
+	namePattern *pattern
+}
+
+func (c *BatchJobDefinitionConfig) Validate() error {
+	if c.Name != "" && c.NamePattern != "" {
+		return errors.New("batch_job_definitions name and name_pattern are exclusive")
+	}
+	var err error
+	if c.namePattern, err = compileNamePattern(c.NamePattern); err != nil {
+		return fmt.Errorf("batch_job_definitions %w", err)
+	}
+	if c.KeepCount == 0 {
+		log.Printf(
+			"[warn] keep_count for batch_job_definitions %s%s is not defined. set default keep_count to %d",
+			c.Name,
+			c.NamePattern,
+			DefaultKeepCount,
+		)
+		c.KeepCount = int64(DefaultKeepCount)
+	}
+	return nil
+}
+
+func (c *BatchJobDefinitionConfig) Match(name string) bool {
+	if c.Name == name {
+		return true
+	}
+	return matchPattern(c.namePattern, c.NamePattern, name)
+}
+
+type BatchJobQueueConfig struct {
+	Name        string `yaml:"name,omitempty"`
+	NamePattern string `yaml:"name_pattern,omitempty"`
+
+	namePattern *pattern
+}
+
+func (c *BatchJobQueueConfig) Validate() error {
+	if c.Name == "" && c.NamePattern == "" {
+		return errors.New("batch_job_queues name or name_pattern is required")
+	}
+	var err error
+	if c.namePattern, err = compileNamePattern(c.NamePattern); err != nil {
+		return fmt.Errorf("batch_job_queues %w", err)
+	}
+	return nil
+}
+
+func (c *BatchJobQueueConfig) Match(name string) bool {
+	if c.Name == name {
+		return true
+	}
+	return matchPattern(c.namePattern, c.NamePattern, name)
 }
@@ -0,0 +1,174 @@
+package ecrm_test
+
+import (
+	"testing"
+
+	"github.com/fujiwara/ecrm"
+)
+
+func TestLayeredRepositoryConfig(t *testing.T) {
+	c, err := ecrm.LoadConfig("testdata/layered.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		repo         ecrm.RepositoryName
+		expires      string
+		keepCount    int64
+		pulledWithin string
+		rule         string
+	}{
+		{"prod/app", "90d", 10, "7d", "name=prod/app, name_pattern=prod/*, name_pattern=*, defaults"},
+		{"prod/web", "90d", 3, "7d", "name_pattern=prod/*, name_pattern=*, defaults"},
+		{"sandbox/app", "1d", 0, "", "name_pattern=sandbox/*, defaults"},
+		{"dev/app", "30d", 3, "7d", "name_pattern=*, defaults"},
+	}
+	for _, tt := range tests {
+		rc := c.EffectiveRepositoryConfig(tt.repo)
+		if rc == nil {
+			t.Errorf("%s: no rule matched", tt.repo)
+			continue
+		}
+		if rc.Expires != tt.expires || rc.KeepCount != tt.keepCount || rc.PulledWithin != tt.pulledWithin {
+			t.Errorf("%s: unexpected effective config expires=%s keep_count=%d pulled_within=%s", tt.repo, rc.Expires, rc.KeepCount, rc.PulledWithin)
+		}
+		if rc.String() != tt.rule {
+			t.Errorf("%s: unexpected rules %s", tt.repo, rc.String())
+		}
+		if rc.Action != ecrm.ActionDelete || len(rc.KeepTagPatterns) != 1 {
+			t.Errorf("%s: defaults are not applied action=%s keep_tag_patterns=%v", tt.repo, rc.Action, rc.KeepTagPatterns)
+		}
+	}
+}
+
+func TestLayeredRepositoryConfigKeepTagPatterns(t *testing.T) {
+	c := &ecrm.Config{
+		Repositories: []*ecrm.RepositoryConfig{
+			{NamePattern: "app-*", Expires: "30d"},
+			{NamePattern: "web-*", Expires: "30d", KeepTagPatterns: []string{"stable"}},
+			{NamePattern: "*", Expires: "90d", KeepTagPatterns: []string{"release-*"}},
+		},
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		repo ecrm.RepositoryName
+		tag  string
+		keep bool
+	}{
+		// the narrow rule keeps the default patterns in addition to the inherited ones
+		{"app-api", "latest", true},
+		{"app-api", "release-1", true},
+		{"app-api", "v1", false},
+		// the rule setting keep_tag_patterns does not keep the default patterns
+		{"web-api", "latest", false},
+		{"web-api", "stable", true},
+		{"db", "latest", false},
+		{"db", "release-1", true},
+	}
+	for _, tt := range tests {
+		rc := c.EffectiveRepositoryConfig(tt.repo)
+		if rc == nil {
+			t.Fatalf("%s: no rule matched", tt.repo)
+		}
+		if rc.MatchTag(tt.tag) != tt.keep {
+			t.Errorf("%s: unexpected match of tag %s with keep_tag_patterns %v", tt.repo, tt.tag, rc.KeepTagPatterns)
+		}
+	}
+}
+
+func TestLayeredRepositoryConfigValidate(t *testing.T) {
+	noExpires := &ecrm.Config{Repositories: []*ecrm.RepositoryConfig{{NamePattern: "*"}}}
+	if err := noExpires.Validate(); err == nil {
+		t.Error("expires is required without defaults")
+	}
+	withDefaults := &ecrm.Config{
+		Defaults:     &ecrm.RepositoryConfig{Expires: "30d", Action: ecrm.ActionArchive},
+		Repositories: []*ecrm.RepositoryConfig{{NamePattern: "*", DeleteAfter: "90d"}},
+	}
+	if err := withDefaults.Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	invalidDefaults := &ecrm.Config{
+		Defaults:     &ecrm.RepositoryConfig{NamePattern: "*", Expires: "30d"},
+		Repositories: []*ecrm.RepositoryConfig{{NamePattern: "*"}},
+	}
+	if err := invalidDefaults.Validate(); err == nil {
+		t.Error("defaults cannot have name_pattern")
+	}
+}
+
+func TestValidateConfigFile(t *testing.T) {
+	issues, err := ecrm.ValidateConfigFile("testdata/lint.yaml", "testdata/lint_resources.json", "7d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		severity string
+		check    string
+		path     string
+	}{
+		{ecrm.SeverityError, ecrm.CheckValidation, ""},
+		{ecrm.SeverityWarning, ecrm.CheckShadowed, "repositories[1]"},
+		{ecrm.SeverityWarning, ecrm.CheckShadowed, "repositories[3]"},
+		{ecrm.SeverityWarning, ecrm.CheckRedundant, "clusters[1]"},
+		{ecrm.SeverityWarning, ecrm.CheckShadowed, "task_definitions[1]"},
+		{ecrm.SeverityWarning, ecrm.CheckUnmatched, "repositories[4]"},
+		{ecrm.SeverityWarning, ecrm.CheckUnmatched, "task_definitions[2]"},
+		{ecrm.SeverityWarning, ecrm.CheckShortExpires, "repositories[2]"},
+		{ecrm.SeverityWarning, ecrm.CheckDeprecated, "lambda_functions[0]"},
+	}
+	if len(issues) != len(expected) {
+		t.Fatalf("unexpected issues: %v", issues)
+	}
+	for i, e := range expected {
+		if issues[i].Severity != e.severity || issues[i].Check != e.check || issues[i].Path != e.path {
+			t.Errorf("unexpected issue[%d]: %s", i, issues[i])
+		}
+	}
+	if issues.Errors() != 1 {
+		t.Errorf("unexpected errors: %d", issues.Errors())
+	}
+}
+
+func TestValidateConfigFileZeroValueOverride(t *testing.T) {
+	issues, err := ecrm.ValidateConfigFile("testdata/lint_zero.yaml", "", "7d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// prod/app overrides keep_count of the defaults by zero, but stg/app sets keep_count already set by stg/*
+	if len(issues) != 1 || issues[0].Check != ecrm.CheckShadowed || issues[0].Path != "repositories[3]" {
+		t.Errorf("unexpected issues: %v", issues)
+	}
+}
+
+func TestConfigValidateReportsAllErrors(t *testing.T) {
+	c := &ecrm.Config{
+		Repositories: []*ecrm.RepositoryConfig{
+			{NamePattern: "a/*"},
+			{NamePattern: "b/*", Expires: "30d", Action: "remove"},
+		},
+	}
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok || len(joined.Unwrap()) != 2 {
+		t.Errorf("expected 2 errors: %s", err)
+	}
+}
+
+func TestAppRunnerConfig(t *testing.T) {
+	ac := &ecrm.AppRunnerConfig{NamePattern: "prod-*"}
+	if err := ac.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !ac.Match("prod-web") || ac.Match("dev-web") {
+		t.Error("unexpected match of app_runner_services")
+	}
+	if err := (&ecrm.AppRunnerConfig{}).Validate(); err == nil {
+		t.Error("name or name_pattern is required")
+	}
+}
@@ -0,0 +1,27 @@
+package ecrm
+
+import (
+	"context"
+	"fmt"
+)
+
+// ShowEffectiveConfig shows the effective repositories rule for the repository.
+func (app *App) ShowEffectiveConfig(ctx context.Context, path string, opt *Option) error {
+	c, err := LoadConfig(path)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if opt.Registry != "" && !c.hasRegistry(opt.Registry) {
+		return fmt.Errorf("registry %s is not defined in registries", opt.Registry)
+	}
+	rc := matchRepositoryConfig(c.repositoriesFor(opt.Registry), opt.Repository)
+	if rc == nil {
+		return fmt.Errorf("no repositories rule matches %s. ecrm never deletes images in it", opt.Repository)
+	}
+	w, err := opt.OutputWriter()
+	if err != nil {
+		return fmt.Errorf("failed to open output: %w", err)
+	}
+	defer w.Close()
+	return rc.printEffective(w, opt.Repository)
+}
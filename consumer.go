@@ -0,0 +1,131 @@
+package ecrm
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+const (
+	ConsumerTypeECSTaskDefinition  = "ecs_task_definition"
+	ConsumerTypeECSTask            = "ecs_task"
+	ConsumerTypeECSService         = "ecs_service"
+	ConsumerTypeEventBridgeRule    = "eventbridge_rule"
+	ConsumerTypeSchedulerSchedule  = "scheduler_schedule"
+	ConsumerTypeLambdaFunction     = "lambda_function"
+	ConsumerTypeAppRunnerService   = "app_runner_service"
+	ConsumerTypeBatchJobDefinition = "batch_job_definition"
+	ConsumerTypeBatchJob           = "batch_job"
+	ConsumerTypeExternalCommand    = "external_command"
+	ConsumerTypeKubernetes         = "kubernetes"
+	ConsumerTypeFile               = "file"
+)
+
+// Consumer represents a resource that uses an image.
+type Consumer struct {
+	Type      string    `json:"type,omitempty"`
+	Source    string    `json:"source"`
+	Service   string    `json:"service,omitempty"`
+	Cluster   string    `json:"cluster,omitempty"`
+	Aliases   []string  `json:"aliases,omitempty"`
+	Account   string    `json:"account,omitempty"`
+	Region    string    `json:"region,omitempty"`
+	ScannedAt time.Time `json:"scanned_at,omitzero"`
+}
+
+// newConsumer creates a Consumer. The account and region are taken from the source if it is an ARN.
+func newConsumer(typ, source string) Consumer {
+	c := Consumer{
+		Type:      typ,
+		Source:    source,
+		ScannedAt: time.Now(),
+	}
+	if a, err := arn.Parse(source); err == nil {
+		c.Account = a.AccountID
+		c.Region = a.Region
+	}
+	return c
+}
+
+// String returns a human-readable representation of the consumer. It is also used as the identity of the consumer.
+func (c Consumer) String() string {
+	s := c.Source
+	switch c.Type {
+	case ConsumerTypeExternalCommand:
+		s = "external_command: " + s
+	case ConsumerTypeKubernetes:
+		s = "kubernetes: " + s
+	}
+	var details []string
+	if c.Service != "" {
+		details = append(details, "service:"+c.Service)
+	}
+	if c.Cluster != "" {
+		details = append(details, "cluster:"+c.Cluster)
+	}
+	if len(c.Aliases) > 0 {
+		details = append(details, "aliases:"+strings.Join(c.Aliases, ","))
+	}
+	if len(details) > 0 {
+		s += " (" + strings.Join(details, " ") + ")"
+	}
+	return s
+}
+
+// consumers is a set of consumers keyed by Consumer.String().
+type consumers map[string]Consumer
+
+func newConsumers(cs ...Consumer) consumers {
+	m := make(consumers, len(cs))
+	for _, c := range cs {
+		m[c.String()] = c
+	}
+	return m
+}
+
+func (m consumers) add(c Consumer) bool {
+	k := c.String()
+	if _, ok := m[k]; ok {
+		return false // already exists
+	}
+	m[k] = c
+	return true // added
+}
+
+func (m consumers) isEmpty() bool {
+	return len(m) == 0
+}
+
+func (m consumers) union(o consumers) consumers {
+	u := make(consumers, len(m)+len(o))
+	for k, c := range m {
+		u[k] = c
+	}
+	for k, c := range o {
+		if _, ok := u[k]; !ok {
+			u[k] = c
+		}
+	}
+	return u
+}
+
+// sortedMembers returns the string representations of the consumers in sorted order.
+func (m consumers) sortedMembers() []string {
+	var members []string
+	for k := range m {
+		members = append(members, k)
+	}
+	slices.Sort(members)
+	return members
+}
+
+// list returns the consumers in sorted order.
+func (m consumers) list() []Consumer {
+	list := make([]Consumer, 0, len(m))
+	for _, k := range m.sortedMembers() {
+		list = append(list, m[k])
+	}
+	return list
+}
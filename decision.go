@@ -0,0 +1,222 @@
+package ecrm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/dustin/go-humanize"
+	"github.com/fatih/color"
+	"github.com/olekukonko/tablewriter"
+)
+
+// DecisionReason represents why an image is kept or expired by the planner.
+type DecisionReason string
+
+const (
+	ReasonInUse                   DecisionReason = "in_use"
+	ReasonKeepTagPattern          DecisionReason = "keep_tag_pattern"
+	ReasonNotExpired              DecisionReason = "not_expired"
+	ReasonRecentlyPulled          DecisionReason = "recently_pulled"
+	ReasonKeepSemver              DecisionReason = "keep_semver"
+	ReasonKeepCount               DecisionReason = "keep_count"
+	ReasonImageIndexConstituent   DecisionReason = "image_index_constituent"
+	ReasonNoExpiredImageIndex     DecisionReason = "no_expired_image_index"
+	ReasonSociIndexOfExpiredIndex DecisionReason = "soci_index_of_expired_index"
+	ReasonExpired                 DecisionReason = "expired"
+	ReasonExpireTagPattern        DecisionReason = "expire_tag_pattern"
+	ReasonMaxTotalSize            DecisionReason = "max_total_size"
+	ReasonArchived                DecisionReason = "archived"
+	ReasonArchiveExpired          DecisionReason = "archive_expired"
+)
+
+// ImageDecision represents a decision of the planner for an image.
+type ImageDecision struct {
+	Account  string         `json:"account,omitempty"`
+	Region   string         `json:"region,omitempty"`
+	Repo     RepositoryName `json:"repository"`
+	Type     string         `json:"type"`
+	Digest   string         `json:"digest"`
+	Tags     []string       `json:"tags"`
+	PushedAt time.Time      `json:"pushed_at"`
+	PulledAt time.Time      `json:"pulled_at,omitzero"`
+	Size     int64          `json:"size"`
+	Expired  bool           `json:"expired"`
+	Reason   DecisionReason `json:"reason"`
+	Message  string         `json:"message"`
+	UsedBy   []string       `json:"used_by,omitempty"`
+	Rule     string         `json:"rule,omitempty"`
+
+	QuarantinedAt time.Time        `json:"quarantined_at,omitzero"`
+	SoftDelete    SoftDeleteAction `json:"soft_delete,omitempty"`
+	Status        string           `json:"status,omitempty"`
+	Archive       bool             `json:"archive,omitempty"`
+
+	// chain records the checks that the image passed through before the decision
+	chain []string
+}
+
+func newImageDecision(repo RepositoryName, typ string, d ecrTypes.ImageDetail) *ImageDecision {
+	tags := d.ImageTags
+	if tags == nil {
+		tags = []string{}
+	}
+	return &ImageDecision{
+		Repo:     repo,
+		Type:     typ,
+		Digest:   aws.ToString(d.ImageDigest),
+		Tags:     tags,
+		PushedAt: aws.ToTime(d.ImagePushedAt),
+		PulledAt: aws.ToTime(d.LastRecordedPullTime),
+		Size:     aws.ToInt64(d.ImageSizeInBytes),
+	}
+}
+
+// step records a check in the decision chain.
+func (d *ImageDecision) step(format string, args ...any) {
+	d.chain = append(d.chain, fmt.Sprintf(format, args...))
+}
+
+// Chain returns the checks in the decision chain, and the decision at last.
+func (d *ImageDecision) Chain() []string {
+	return append(slices.Clone(d.chain), fmt.Sprintf("%s: %s", d.action(), d.Message))
+}
+
+func (d *ImageDecision) keep(reason DecisionReason, format string, args ...any) *ImageDecision {
+	d.Expired = false
+	d.Reason = reason
+	d.Message = fmt.Sprintf(format, args...)
+	return d
+}
+
+func (d *ImageDecision) expire(reason DecisionReason, format string, args ...any) *ImageDecision {
+	d.Expired = true
+	d.Reason = reason
+	d.Message = fmt.Sprintf(format, args...)
+	return d
+}
+
+func (d *ImageDecision) action() string {
+	if d.SoftDelete != "" {
+		return string(d.SoftDelete)
+	}
+	if d.Archive {
+		return "archive"
+	}
+	if d.Expired {
+		return "expire"
+	}
+	return "keep"
+}
+
+func (d *ImageDecision) row() []string {
+	tags := strings.Join(d.Tags, ",")
+	if tags == "" {
+		tags = untaggedStr
+	}
+	return []string{
+		string(d.Repo),
+		d.Type,
+		shortDigest(d.Digest),
+		tags,
+		d.PushedAt.Format(time.RFC3339),
+		formatTime(d.PulledAt),
+		humanize.Bytes(uint64(d.Size)),
+		d.action(),
+		d.Message,
+	}
+}
+
+// ImageDecisions is a list of decisions for images.
+type ImageDecisions []*ImageDecision
+
+// PrintDetails prints the summaries and the decisions for each image.
+// withRegistry adds the account and region columns to the tables.
+func (r *PlanResult) PrintDetails(w io.Writer, format outputFormat, withRegistry bool) error {
+	switch format {
+	case formatTable:
+		if err := r.Summary.printTable(w, withRegistry); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+		return r.Decisions.printTable(w, withRegistry)
+	case formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Summary SummaryTable   `json:"summary"`
+			Images  ImageDecisions `json:"images"`
+		}{
+			Summary: r.Summary.printables(),
+			Images:  r.Decisions,
+		})
+	default:
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+}
+
+func (ds ImageDecisions) printTable(w io.Writer, withRegistry bool) error {
+	t := tablewriter.NewWriter(w)
+	header := ds.header()
+	if withRegistry {
+		header = append([]string{"account", "region"}, header...)
+	}
+	t.SetHeader(header)
+	t.SetBorder(false)
+	t.SetAutoWrapText(false)
+	for _, d := range ds {
+		row := d.row()
+		if withRegistry {
+			row = append([]string{d.Account, d.Region}, row...)
+		}
+		action := len(row) - 2
+		colors := make([]tablewriter.Colors, len(row))
+		if d.Expired {
+			colors[action] = tablewriter.Colors{tablewriter.FgBlueColor}
+		} else {
+			colors[action] = tablewriter.Colors{tablewriter.FgYellowColor}
+		}
+		if color.NoColor {
+			t.Append(row)
+		} else {
+			t.Rich(row, colors)
+		}
+	}
+	t.Render()
+	return nil
+}
+
+func (ds ImageDecisions) header() []string {
+	return []string{
+		"repository",
+		"type",
+		"digest",
+		"tags",
+		"pushed at",
+		"pulled at",
+		"size",
+		"action",
+		"reason",
+	}
+}
+
+// formatTime formats the time in RFC3339, or returns an empty string for the zero time.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func shortDigest(digest string) string {
+	algo, hex, found := strings.Cut(digest, ":")
+	if !found || len(hex) <= 12 {
+		return digest
+	}
+	return algo + ":" + hex[:12]
+}
@@ -0,0 +1,233 @@
+package ecrm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/samber/lo"
+)
+
+// ExitCodeDeleteFailures is the exit code when some images could not be deleted.
+const ExitCodeDeleteFailures = 2
+
+// maxDeleteRetries is the max number of retries for images that failed to delete by ordering.
+const maxDeleteRetries = 5
+
+// DeletionGraph represents dependencies between images to be deleted in a repository.
+// It maps an image digest to the digests of images referencing it (image indexes), which must be deleted before it.
+type DeletionGraph map[string][]string
+
+func (g DeletionGraph) add(child, parent string) {
+	if !slices.Contains(g[child], parent) {
+		g[child] = append(g[child], parent)
+	}
+}
+
+// batches splits ids into batches in dependency order. Images in a batch depend only on images in the previous batches.
+func (g DeletionGraph) batches(ids []ecrTypes.ImageIdentifier) [][]ecrTypes.ImageIdentifier {
+	remaining := newSet()
+	for _, id := range ids {
+		remaining.add(aws.ToString(id.ImageDigest))
+	}
+	var batches [][]ecrTypes.ImageIdentifier
+	pending := ids
+	for len(pending) > 0 {
+		var batch, next []ecrTypes.ImageIdentifier
+		for _, id := range pending {
+			ready := true
+			for _, parent := range g[aws.ToString(id.ImageDigest)] {
+				if remaining.contains(parent) {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				batch = append(batch, id)
+			} else {
+				next = append(next, id)
+			}
+		}
+		if len(batch) == 0 {
+			// must not happen, but avoid infinite loop on circular references
+			log.Printf("[warn] circular references found in %d images", len(next))
+			batch, next = next, nil
+		}
+		for _, id := range batch {
+			remaining.remove(aws.ToString(id.ImageDigest))
+		}
+		batches = append(batches, batch)
+		pending = next
+	}
+	return batches
+}
+
+// blockedBy returns the parent that could not be deleted, if any.
+func (g DeletionGraph) blockedBy(digest string, undeleted set) (string, bool) {
+	for _, parent := range g[digest] {
+		if undeleted.contains(parent) {
+			return parent, true
+		}
+	}
+	return "", false
+}
+
+// imageDeleter is an interface of ECR client to delete images.
+type imageDeleter interface {
+	BatchDeleteImage(ctx context.Context, params *ecr.BatchDeleteImageInput, optFns ...func(*ecr.Options)) (*ecr.BatchDeleteImageOutput, error)
+}
+
+// DeleteFailure represents an image that could not be deleted.
+type DeleteFailure struct {
+	Registry string         `json:"registry,omitempty"`
+	Repo     RepositoryName `json:"repository"`
+	Digest   string         `json:"digest"`
+	Code     string         `json:"code"`
+	Reason   string         `json:"reason"`
+}
+
+func (f *DeleteFailure) String() string {
+	s := fmt.Sprintf("%s@%s", f.Repo, f.Digest)
+	if f.Registry != "" {
+		s += " (" + f.Registry + ")"
+	}
+	return fmt.Sprintf("%s %s: %s", s, f.Code, f.Reason)
+}
+
+// DeleteFailures is a list of images that could not be deleted. It is returned as an error.
+type DeleteFailures []*DeleteFailure
+
+func (fs DeleteFailures) Error() string {
+	return fmt.Sprintf("%d images could not be deleted", len(fs))
+}
+
+// ExitCode returns the exit code of the process.
+func (fs DeleteFailures) ExitCode() int {
+	return ExitCodeDeleteFailures
+}
+
+// report logs all failures at last.
+func (fs DeleteFailures) report() {
+	log.Printf("[error] %d images could not be deleted:", len(fs))
+	for _, f := range fs {
+		log.Printf("[error]   %s", f)
+	}
+}
+
+// join reports the failures and joins them with err, so that the failures are not lost
+// when the deletion is aborted by err.
+func (fs DeleteFailures) join(err error) error {
+	if len(fs) == 0 {
+		return err
+	}
+	fs.report()
+	if err == nil {
+		return fs
+	}
+	return errors.Join(err, fs)
+}
+
+// isOrderingFailure reports whether the image failed to delete because other images to be deleted still depend on it.
+func isOrderingFailure(f ecrTypes.ImageFailure) bool {
+	return f.FailureCode == ecrTypes.ImageFailureCodeImageReferencedByManifestList
+}
+
+// batchDeleteImages deletes images in the repository by BatchDeleteImage API.
+// Images that failed to delete by ordering are retried after other images are deleted,
+// as long as some images are deleted in each round.
+func batchDeleteImages(ctx context.Context, client imageDeleter, repo RepositoryName, ids []ecrTypes.ImageIdentifier) (int, DeleteFailures, error) {
+	var deleted int
+	var failures DeleteFailures
+	pending := ids
+	for round := 0; len(pending) > 0; round++ {
+		var retry []ecrTypes.ImageIdentifier
+		var deletedInRound int
+		for _, chunk := range lo.Chunk(pending, batchDeleteImageIdsLimit) {
+			output, err := client.BatchDeleteImage(ctx, &ecr.BatchDeleteImageInput{
+				ImageIds:       chunk,
+				RepositoryName: aws.String(string(repo)),
+			})
+			if err != nil {
+				return deleted, failures, err
+			}
+			deletedInRound += len(output.ImageIds)
+			for _, f := range output.Failures {
+				var digest string
+				if f.ImageId != nil {
+					digest = aws.ToString(f.ImageId.ImageDigest)
+				}
+				log.Printf("[warn] failed to delete %s@%s: %s %s", repo, digest, f.FailureCode, aws.ToString(f.FailureReason))
+				if isOrderingFailure(f) && f.ImageId != nil {
+					retry = append(retry, *f.ImageId)
+					continue
+				}
+				failures = append(failures, &DeleteFailure{
+					Repo:   repo,
+					Digest: digest,
+					Code:   string(f.FailureCode),
+					Reason: aws.ToString(f.FailureReason),
+				})
+			}
+		}
+		deleted += deletedInRound
+		if len(retry) == 0 {
+			break
+		}
+		if deletedInRound == 0 || round >= maxDeleteRetries {
+			// the images are referenced by images that are not going to be deleted
+			for _, id := range retry {
+				failures = append(failures, &DeleteFailure{
+					Repo:   repo,
+					Digest: aws.ToString(id.ImageDigest),
+					Code:   string(ecrTypes.ImageFailureCodeImageReferencedByManifestList),
+					Reason: "still referenced by other images after retries",
+				})
+			}
+			break
+		}
+		log.Printf("[info] retrying to delete %d images on %s after the images depending on them are deleted", len(retry), repo)
+		pending = retry
+	}
+	return deleted, failures, nil
+}
+
+// deleteImagesInOrder deletes images in dependency order of the graph.
+// Images referenced by images that could not be deleted are skipped and reported as failures.
+func deleteImagesInOrder(ctx context.Context, client imageDeleter, repo RepositoryName, ids []ecrTypes.ImageIdentifier, graph DeletionGraph) (int, DeleteFailures, error) {
+	var deleted int
+	var failures DeleteFailures
+	undeleted := newSet()
+	for _, batch := range graph.batches(ids) {
+		ready := make([]ecrTypes.ImageIdentifier, 0, len(batch))
+		for _, id := range batch {
+			digest := aws.ToString(id.ImageDigest)
+			if parent, blocked := graph.blockedBy(digest, undeleted); blocked {
+				log.Printf("[warn] skip deleting %s@%s because %s could not be deleted", repo, digest, parent)
+				undeleted.add(digest)
+				failures = append(failures, &DeleteFailure{
+					Repo:   repo,
+					Digest: digest,
+					Code:   "DependencyNotDeleted",
+					Reason: fmt.Sprintf("referencing image %s could not be deleted", parent),
+				})
+				continue
+			}
+			ready = append(ready, id)
+		}
+		n, fs, err := batchDeleteImages(ctx, client, repo, ready)
+		deleted += n
+		failures = append(failures, fs...)
+		for _, f := range fs {
+			undeleted.add(f.Digest)
+		}
+		if err != nil {
+			return deleted, failures, err
+		}
+	}
+	return deleted, failures, nil
+}
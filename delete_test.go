@@ -0,0 +1,173 @@
+package ecrm_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/fujiwara/ecrm"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeDeleter deletes images in order, and fails to delete images referenced by existing image indexes.
+type fakeDeleter struct {
+	images  map[string]bool
+	parents map[string]string // child digest -> index digest
+	fails   map[string]ecrTypes.ImageFailureCode
+	calls   int
+	deleted []string
+}
+
+func (f *fakeDeleter) BatchDeleteImage(ctx context.Context, in *ecr.BatchDeleteImageInput, _ ...func(*ecr.Options)) (*ecr.BatchDeleteImageOutput, error) {
+	f.calls++
+	out := &ecr.BatchDeleteImageOutput{}
+	for _, id := range in.ImageIds {
+		digest := aws.ToString(id.ImageDigest)
+		switch {
+		case f.fails[digest] != "":
+			out.Failures = append(out.Failures, ecrTypes.ImageFailure{
+				ImageId:       &id,
+				FailureCode:   f.fails[digest],
+				FailureReason: aws.String("failed"),
+			})
+		case !f.images[digest]:
+			out.Failures = append(out.Failures, ecrTypes.ImageFailure{
+				ImageId:       &id,
+				FailureCode:   ecrTypes.ImageFailureCodeImageNotFound,
+				FailureReason: aws.String("Requested image not found"),
+			})
+		case f.images[f.parents[digest]]:
+			out.Failures = append(out.Failures, ecrTypes.ImageFailure{
+				ImageId:       &id,
+				FailureCode:   ecrTypes.ImageFailureCodeImageReferencedByManifestList,
+				FailureReason: aws.String("Requested image referenced by manifest list"),
+			})
+		default:
+			delete(f.images, digest)
+			f.deleted = append(f.deleted, digest)
+			out.ImageIds = append(out.ImageIds, id)
+		}
+	}
+	return out, nil
+}
+
+func ids(digests ...string) []ecrTypes.ImageIdentifier {
+	var ids []ecrTypes.ImageIdentifier
+	for _, d := range digests {
+		ids = append(ids, ecrTypes.ImageIdentifier{ImageDigest: aws.String(d)})
+	}
+	return ids
+}
+
+func TestBatchDeleteImages(t *testing.T) {
+	f := &fakeDeleter{
+		images: map[string]bool{
+			"sha256:index": true, "sha256:amd64": true, "sha256:arm64": true,
+			"sha256:kept-index": true, "sha256:kept-child": true,
+		},
+		parents: map[string]string{
+			"sha256:amd64":      "sha256:index",
+			"sha256:arm64":      "sha256:index",
+			"sha256:kept-child": "sha256:kept-index",
+		},
+	}
+	deleted, failures, err := ecrm.BatchDeleteImages(context.Background(), f, "my-service",
+		ids("sha256:amd64", "sha256:arm64", "sha256:index", "sha256:kept-child", "sha256:missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 3 {
+		t.Errorf("unexpected deleted count: %d", deleted)
+	}
+	if f.calls != 3 {
+		t.Errorf("unexpected calls: %d", f.calls)
+	}
+	got := map[string]string{}
+	for _, fl := range failures {
+		got[fl.Digest] = fl.Code
+	}
+	expected := map[string]string{
+		"sha256:kept-child": "ImageReferencedByManifestList",
+		"sha256:missing":    "ImageNotFound",
+	}
+	if len(got) != len(expected) {
+		t.Errorf("unexpected failures: %v", got)
+	}
+	for digest, code := range expected {
+		if got[digest] != code {
+			t.Errorf("unexpected failure of %s: %s", digest, got[digest])
+		}
+	}
+
+	var fs ecrm.DeleteFailures
+	if !errors.As(fmt.Errorf("wrapped: %w", failures), &fs) || fs.ExitCode() != ecrm.ExitCodeDeleteFailures {
+		t.Error("failures should have an exit code")
+	}
+}
+
+func TestDeleteImagesInOrder(t *testing.T) {
+	f := &fakeDeleter{
+		images: map[string]bool{
+			"sha256:index": true, "sha256:amd64": true, "sha256:arm64": true,
+			"sha256:referrer": true, "sha256:soci": true,
+			"sha256:broken-index": true, "sha256:broken-child": true,
+		},
+		fails: map[string]ecrTypes.ImageFailureCode{
+			"sha256:broken-index": ecrTypes.ImageFailureCodeKmsError,
+		},
+	}
+	graph := ecrm.DeletionGraph{
+		"sha256:amd64":        {"sha256:index"},
+		"sha256:arm64":        {"sha256:index"},
+		"sha256:soci":         {"sha256:referrer"},
+		"sha256:broken-child": {"sha256:broken-index"},
+	}
+	deleted, failures, err := ecrm.DeleteImagesInOrder(context.Background(), f, "my-service",
+		ids("sha256:amd64", "sha256:soci", "sha256:broken-child", "sha256:arm64", "sha256:index", "sha256:referrer", "sha256:broken-index"),
+		graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 5 {
+		t.Errorf("unexpected deleted count: %d", deleted)
+	}
+	expected := []string{"sha256:index", "sha256:referrer", "sha256:amd64", "sha256:soci", "sha256:arm64"}
+	if diff := cmp.Diff(expected, f.deleted); diff != "" {
+		t.Errorf("unexpected deletion order: %s", diff)
+	}
+	got := map[string]string{}
+	for _, fl := range failures {
+		got[fl.Digest] = fl.Code
+	}
+	if diff := cmp.Diff(map[string]string{
+		"sha256:broken-index": "KmsError",
+		"sha256:broken-child": "DependencyNotDeleted",
+	}, got); diff != "" {
+		t.Errorf("unexpected failures: %s", diff)
+	}
+}
+
+func TestDeleteFailuresJoin(t *testing.T) {
+	var none ecrm.DeleteFailures
+	if err := none.Join(nil); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	aborted := errors.New("aborted")
+	if err := none.Join(aborted); err != aborted {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	failures := ecrm.DeleteFailures{{Repo: "foo", Digest: "sha256:missing", Code: "ImageNotFound"}}
+	err := failures.Join(aborted)
+	if !errors.Is(err, aborted) {
+		t.Errorf("error should wrap the cause: %s", err)
+	}
+	var fs ecrm.DeleteFailures
+	if !errors.As(err, &fs) || len(fs) != 1 {
+		t.Errorf("error should keep the failures: %s", err)
+	}
+}
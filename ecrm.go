@@ -6,13 +6,13 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/Songmu/prompter"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsConfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ecr"
 	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
-	"github.com/samber/lo"
 )
 
 const (
@@ -51,38 +51,145 @@ func (app *App) Run(ctx context.Context, path string, opt *Option) error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	scanner := NewScanner(app.awsCfg)
-	if err := scanner.LoadFiles(opt.ScannedFiles); err != nil {
-		return fmt.Errorf("failed to load scanned image URIs: %w", err)
-	}
-	if opt.Scan {
-		if err := scanner.Scan(ctx, c); err != nil {
-			return fmt.Errorf("failed to scan: %w", err)
-		}
+	scanner, err := app.scan(ctx, c, opt.Scan, opt.ScannedFiles)
+	if err != nil {
+		return err
 	}
-	log.Println("[info] total", len(scanner.Images), "image URIs in use")
 	if opt.ScanOnly {
 		return ShowScanResult(scanner, opt)
 	}
 
-	planner := NewPlanner(app.awsCfg)
-	sums, candidates, err := planner.Plan(ctx, c.Repositories, scanner.Images, opt.Repository)
+	regs, err := app.registries(ctx, c)
 	if err != nil {
-		return fmt.Errorf("failed to plan: %w", err)
+		return err
 	}
-	if err := ShowSummary(sums, opt); err != nil {
+	results := make(PlanResults, 0, len(regs))
+	for _, reg := range regs {
+		result, err := reg.plan(ctx, c, scanner.Images, opt.Repository)
+		if err != nil {
+			return fmt.Errorf("failed to plan: %w", err)
+		}
+		results = append(results, result)
+	}
+	if opt.Detail {
+		if err := ShowDetails(results.Merge(), len(c.Registries) > 0, opt); err != nil {
+			return fmt.Errorf("failed to show details: %w", err)
+		}
+	} else if err := ShowSummary(results.Merge().Summary, len(c.Registries) > 0, opt); err != nil {
 		return fmt.Errorf("failed to show summary: %w", err)
 	}
+	if opt.PlanFile != "" {
+		pf := NewPlanFile(c, opt, results)
+		if err := pf.Save(opt.PlanFile); err != nil {
+			return fmt.Errorf("failed to save plan: %w", err)
+		}
+	}
 
 	if !opt.Delete {
+		if err := checkDeleteLimits(c, results); err != nil {
+			log.Printf("[warn] delete will be aborted: %s", err)
+		}
 		return nil
 	}
-	for _, name := range candidates.RepositoryNames() {
-		if err := app.DeleteImages(ctx, name, candidates[name], opt.Force); err != nil {
-			return fmt.Errorf("failed to delete images: %w", err)
+	if err := checkDeleteLimits(c, results); err != nil {
+		return err
+	}
+	var failures DeleteFailures
+	for i, reg := range regs {
+		fs, err := app.execute(ctx, reg, results[i], opt.Force)
+		failures = append(failures, fs...)
+		if err != nil {
+			return failures.join(err)
 		}
 	}
-	return nil
+	return failures.join(nil)
+}
+
+// Apply deletes images exactly as planned in the plan file, after checking the plan is still fresh.
+func (app *App) Apply(ctx context.Context, path string, opt *Option) error {
+	if err := opt.Validate(); err != nil {
+		return fmt.Errorf("invalid option: %w", err)
+	}
+	pf, err := LoadPlanFile(opt.PlanFile)
+	if err != nil {
+		return err
+	}
+	c, err := LoadConfig(path)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	regs, err := app.registries(ctx, c)
+	if err != nil {
+		return err
+	}
+	if err := pf.VerifyConfig(c, regions(regs)); err != nil {
+		return err
+	}
+
+	scanner, err := app.scan(ctx, c, pf.Scan, pf.ScannedFiles)
+	if err != nil {
+		return err
+	}
+	for _, reg := range regs {
+		pr := pf.Registry(reg.name)
+		if pr == nil {
+			continue
+		}
+		result, err := reg.plan(ctx, c, scanner.Images, pf.Repository)
+		if err != nil {
+			return fmt.Errorf("failed to plan: %w", err)
+		}
+		current := make(map[RepositoryName]map[string]ecrTypes.ImageDetail)
+		for _, planned := range []map[RepositoryName][]string{pr.Images, pr.Quarantine, pr.Restore, pr.Archive} {
+			for name := range planned {
+				if _, ok := current[name]; ok {
+					continue
+				}
+				if current[name], err = reg.planner.currentImages(ctx, name); err != nil {
+					return fmt.Errorf("failed to list images in %s on registry %s: %w", name, reg, err)
+				}
+			}
+		}
+		if err := pr.VerifyImages(current, result, scanner.Images); err != nil {
+			return fmt.Errorf("registry %s: %w", reg, err)
+		}
+	}
+	log.Printf("[info] plan created at %s is fresh", pf.CreatedAt.Format(time.RFC3339))
+	if err := checkDeleteLimits(c, pf.Results()); err != nil {
+		return err
+	}
+	if err := ShowSummary(pf.Summary, len(c.Registries) > 0, opt); err != nil {
+		return fmt.Errorf("failed to show summary: %w", err)
+	}
+
+	var failures DeleteFailures
+	for _, reg := range regs {
+		pr := pf.Registry(reg.name)
+		if pr == nil {
+			continue
+		}
+		fs, err := app.execute(ctx, reg, pr.result(), opt.Force)
+		failures = append(failures, fs...)
+		if err != nil {
+			return failures.join(err)
+		}
+	}
+	return failures.join(nil)
+}
+
+// scan loads the scanned files and scans resources in use.
+func (app *App) scan(ctx context.Context, c *Config, scan bool, files []string) (*Scanner, error) {
+	scanner := NewScanner(app.awsCfg)
+	if err := scanner.LoadFiles(files); err != nil {
+		return nil, fmt.Errorf("failed to load scanned image URIs: %w", err)
+	}
+	if scan {
+		if err := scanner.Scan(ctx, c); err != nil {
+			return nil, fmt.Errorf("failed to scan: %w", err)
+		}
+	}
+	log.Println("[info] total", len(scanner.Images), "image URIs in use")
+	return scanner, nil
 }
 
 func ShowScanResult(s *Scanner, opt *Option) error {
@@ -91,55 +198,91 @@ func ShowScanResult(s *Scanner, opt *Option) error {
 		return fmt.Errorf("failed to open output: %w", err)
 	}
 	defer w.Close()
-	if err := s.Save(w); err != nil {
+	if err := s.Save(w, opt.ScanFormatVersion); err != nil {
 		return fmt.Errorf("failed to save scanned image URIs: %w", err)
 	}
 	return nil
 }
 
-func ShowSummary(s SummaryTable, opt *Option) error {
+// ShowSummary shows the summaries. The account and region columns are shown when withRegistry is true (registries are configured).
+func ShowSummary(s SummaryTable, withRegistry bool, opt *Option) error {
+	w, err := opt.OutputWriter()
+	if err != nil {
+		return fmt.Errorf("failed to open output: %w", err)
+	}
+	defer w.Close()
+	return s.Print(w, opt.Format, withRegistry)
+}
+
+// ShowDetails shows the summaries and the decisions. The account and region columns are shown when withRegistry is true.
+func ShowDetails(r *PlanResult, withRegistry bool, opt *Option) error {
 	w, err := opt.OutputWriter()
 	if err != nil {
 		return fmt.Errorf("failed to open output: %w", err)
 	}
 	defer w.Close()
-	return s.Print(w, opt.Format)
+	return r.PrintDetails(w, opt.Format, withRegistry)
 }
 
 const batchDeleteImageIdsLimit = 100
 const batchGetImageLimit = 100
 
-// DeleteImages deletes images from the repository
+// execute restores, quarantines and deletes images in the registry as the result.
+func (app *App) execute(ctx context.Context, reg *registry, r *PlanResult, force bool) (DeleteFailures, error) {
+	for _, name := range r.Restore.RepositoryNames() {
+		if err := app.restoreImages(ctx, reg, name, r.Restore[name]); err != nil {
+			return nil, fmt.Errorf("failed to restore images: %w", err)
+		}
+	}
+	for _, name := range r.Archive.RepositoryNames() {
+		if err := app.archiveImages(ctx, reg, name, r.Archive[name], force); err != nil {
+			return nil, fmt.Errorf("failed to archive images: %w", err)
+		}
+	}
+	for _, name := range r.Quarantine.RepositoryNames() {
+		if err := app.quarantineImages(ctx, reg, name, r.Quarantine[name], force); err != nil {
+			return nil, fmt.Errorf("failed to quarantine images: %w", err)
+		}
+	}
+	var failures DeleteFailures
+	for _, name := range r.Deletable.RepositoryNames() {
+		fs, err := app.deleteImages(ctx, reg, name, r.Deletable[name], r.Dependencies[name], force)
+		failures = append(failures, fs...)
+		if err != nil {
+			return failures, fmt.Errorf("failed to delete images: %w", err)
+		}
+	}
+	return failures, nil
+}
+
+// DeleteImages deletes images from the repository in the registry of the default credentials.
+// When some images could not be deleted, DeleteFailures is returned.
 func (app *App) DeleteImages(ctx context.Context, repo RepositoryName, ids []ecrTypes.ImageIdentifier, force bool) error {
+	reg := &registry{region: app.region, planner: &Planner{ecr: app.ecr, region: app.region}}
+	failures, err := app.deleteImages(ctx, reg, repo, ids, nil, force)
+	return failures.join(err)
+}
+
+func (app *App) deleteImages(ctx context.Context, reg *registry, repo RepositoryName, ids []ecrTypes.ImageIdentifier, graph DeletionGraph, force bool) (DeleteFailures, error) {
 	if len(ids) == 0 {
-		log.Println("[info] no need to delete images on", repo)
-		return nil
+		log.Printf("[info] no need to delete images on %s (%s)", repo, reg)
+		return nil, nil
 	}
 	if !force {
-		if !prompter.YN(fmt.Sprintf("Do you delete %d images on %s?", len(ids), repo), false) {
-			return errors.New("aborted")
+		if !prompter.YN(fmt.Sprintf("Do you delete %d images on %s (%s)?", len(ids), repo, reg), false) {
+			return nil, errors.New("aborted")
 		}
 	}
 
 	for _, id := range ids {
-		log.Printf("[notice] Deleting %s %s", repo, *id.ImageDigest)
-	}
-	chunkIDs := lo.Chunk(ids, batchDeleteImageIdsLimit)
-	var deletedCount int
-	defer func() {
-		log.Printf("[info] Deleted %d images on %s", deletedCount, repo)
-	}()
-	for _, ids := range chunkIDs {
-		output, err := app.ecr.BatchDeleteImage(ctx, &ecr.BatchDeleteImageInput{
-			ImageIds:       ids,
-			RepositoryName: aws.String(string(repo)),
-		})
-		if err != nil {
-			return err
-		}
-		deletedCount += len(output.ImageIds)
+		log.Printf("[notice] Deleting %s %s (%s)", repo, *id.ImageDigest, reg)
 	}
-	return nil
+	deletedCount, failures, err := deleteImagesInOrder(ctx, reg.planner.ecr, repo, ids, graph)
+	log.Printf("[info] Deleted %d images on %s (%s)", deletedCount, repo, reg)
+	for _, f := range failures {
+		f.Registry = reg.String()
+	}
+	return failures, err
 }
 
 func (app *App) GenerateConfig(ctx context.Context, path string) error {
@@ -3,7 +3,10 @@ package ecrm_test
 import (
 	"testing"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
 	"github.com/fujiwara/ecrm"
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestParseTaskDefArn(t *testing.T) {
@@ -16,3 +19,67 @@ func TestParseTaskDefArn(t *testing.T) {
 		t.Errorf("unexpected task definition: %s", td)
 	}
 }
+
+func TestServiceTaskdefs(t *testing.T) {
+	tdArn := func(s string) *string {
+		return aws.String("arn:aws:ecs:ap-northeast-1:012345678901:task-definition/" + s)
+	}
+	deployment := func(status, td string) ecsTypes.Deployment {
+		return ecsTypes.Deployment{Status: aws.String(status), TaskDefinition: tdArn(td)}
+	}
+	taskSet := func(status, td string) ecsTypes.TaskSet {
+		return ecsTypes.TaskSet{Id: aws.String("ecs-svc/" + td), Status: aws.String(status), TaskDefinition: tdArn(td)}
+	}
+	tests := []struct {
+		name    string
+		service ecsTypes.Service
+		want    []string
+	}{
+		{
+			name: "ECS",
+			service: ecsTypes.Service{
+				DeploymentController: &ecsTypes.DeploymentController{Type: ecsTypes.DeploymentControllerTypeEcs},
+				Deployments:          []ecsTypes.Deployment{deployment("PRIMARY", "web:2"), deployment("ACTIVE", "web:1")},
+			},
+			want: []string{"web:2 web/prod", "web:1 web/prod"},
+		},
+		{
+			name: "CODE_DEPLOY",
+			service: ecsTypes.Service{
+				DeploymentController: &ecsTypes.DeploymentController{Type: ecsTypes.DeploymentControllerTypeCodeDeploy},
+				Deployments:          []ecsTypes.Deployment{deployment("PRIMARY", "web:5")},
+				TaskSets: []ecsTypes.TaskSet{
+					taskSet("PRIMARY", "web:5"),
+					taskSet("ACTIVE", "web:6"),
+					taskSet("DRAINING", "web:4"),
+				},
+			},
+			want: []string{"web:5 web/prod", "web:6 web/prod", "web:4 web/prod"},
+		},
+		{
+			name: "EXTERNAL",
+			service: ecsTypes.Service{
+				DeploymentController: &ecsTypes.DeploymentController{Type: ecsTypes.DeploymentControllerTypeExternal},
+				TaskSets: []ecsTypes.TaskSet{
+					taskSet("PRIMARY", "web:8"),
+					taskSet("ACTIVE", "web:9"),
+					taskSet("DRAINING", "web:7"),
+					taskSet("ACTIVE", "web:9"),
+				},
+			},
+			want: []string{"web:8 web/prod", "web:9 web/prod", "web:7 web/prod"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.service.ServiceName = aws.String("web")
+			got, err := ecrm.ServiceTaskdefs(tt.service, "prod")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected task definitions (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
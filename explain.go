@@ -0,0 +1,227 @@
+package ecrm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// explainTarget is an image to be explained, specified by an image URI or a digest.
+type explainTarget struct {
+	raw     string
+	account string
+	region  string
+	repo    RepositoryName
+	tag     string
+	digest  string
+}
+
+func parseExplainTarget(s string) explainTarget {
+	t := explainTarget{raw: s}
+	if strings.HasPrefix(s, "sha256:") {
+		t.digest = s
+		return t
+	}
+	u := ImageURI(s)
+	t.account, t.region = u.Registry()
+	t.repo = u.RepositoryName()
+	t.digest = u.Digest()
+	t.tag = u.Tag()
+	if t.digest == "" && t.tag == "" {
+		t.tag = "latest"
+	}
+	return t
+}
+
+func (t explainTarget) match(d *ImageDecision) bool {
+	if t.repo != "" && t.repo != d.Repo {
+		return false
+	}
+	// decisions of a single registry may not have the account and the region
+	if t.account != "" && d.Account != "" && t.account != d.Account {
+		return false
+	}
+	if t.region != "" && d.Region != "" && t.region != d.Region {
+		return false
+	}
+	if t.digest != "" {
+		return t.digest == d.Digest
+	}
+	return slices.Contains(d.Tags, t.tag)
+}
+
+// Explanation represents why an image is kept or expired.
+type Explanation struct {
+	Target     string         `json:"target"`
+	Repository RepositoryName `json:"repository,omitempty"`
+	Rule       string         `json:"rule,omitempty"`
+	Image      *ImageDecision `json:"image,omitempty"`
+	Chain      []string       `json:"chain,omitempty"`
+	Consumers  []string       `json:"consumers,omitempty"`
+	Message    string         `json:"message,omitempty"`
+}
+
+type Explanations []*Explanation
+
+// Explain scans resources and plans, and explains why the images are kept or expired.
+func (app *App) Explain(ctx context.Context, path string, opt *Option) error {
+	if err := opt.Validate(); err != nil {
+		return fmt.Errorf("invalid option: %w", err)
+	}
+	c, err := LoadConfig(path)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	scanner, err := app.scan(ctx, c, opt.Scan, opt.ScannedFiles)
+	if err != nil {
+		return err
+	}
+
+	targets := make([]explainTarget, 0, len(opt.ExplainImages))
+	repos := newSet()
+	allRepos := false
+	for _, s := range opt.ExplainImages {
+		t := parseExplainTarget(s)
+		targets = append(targets, t)
+		if t.repo == "" {
+			allRepos = true
+		} else if matchRepositoryConfig(c.allRepositories(), t.repo) != nil {
+			repos.add(string(t.repo))
+		}
+	}
+	if allRepos {
+		repos = newSet("")
+	}
+
+	regs, err := app.registries(ctx, c)
+	if err != nil {
+		return err
+	}
+	var decisions ImageDecisions
+	for _, reg := range regs {
+		for _, repo := range repos.sortedMembers() {
+			result, err := reg.plan(ctx, c, scanner.Images, RepositoryName(repo))
+			if err != nil {
+				return fmt.Errorf("failed to plan: %w", err)
+			}
+			decisions = append(decisions, result.Decisions...)
+		}
+	}
+
+	exps := make(Explanations, 0, len(targets))
+	for _, t := range targets {
+		exps = append(exps, explain(t, c.allRepositories(), decisions, scanner.Images)...)
+	}
+
+	w, err := opt.OutputWriter()
+	if err != nil {
+		return fmt.Errorf("failed to open output: %w", err)
+	}
+	defer w.Close()
+	return exps.Print(w, opt.Format)
+}
+
+func explain(t explainTarget, rcs []*RepositoryConfig, decisions ImageDecisions, keepImages Images) Explanations {
+	if t.repo != "" {
+		if rc := matchRepositoryConfig(rcs, t.repo); rc == nil {
+			return Explanations{{
+				Target:     t.raw,
+				Repository: t.repo,
+				Message:    "no repositories rule matches the repository. ecrm never deletes images in it",
+			}}
+		}
+	}
+	var exps Explanations
+	for _, d := range decisions {
+		if !t.match(d) {
+			continue
+		}
+		log.Printf("[debug] %s is %s", t.raw, d.Reason)
+		exps = append(exps, &Explanation{
+			Target:     t.raw,
+			Repository: d.Repo,
+			Rule:       d.Rule,
+			Image:      d,
+			Chain:      d.Chain(),
+			Consumers:  decisionUsedBy(d, keepImages),
+		})
+	}
+	if len(exps) == 0 {
+		return Explanations{{
+			Target:     t.raw,
+			Repository: t.repo,
+			Message:    "image not found in the repositories managed by ecrm",
+		}}
+	}
+	return exps
+}
+
+// decisionUsedBy returns the sources that use the image of the decision in its registry by digest or by tags.
+func decisionUsedBy(d *ImageDecision, keepImages Images) []string {
+	return imageUsedBy(ecrTypes.ImageDetail{
+		RegistryId:     aws.String(d.Account),
+		RepositoryName: aws.String(string(d.Repo)),
+		ImageDigest:    aws.String(d.Digest),
+		ImageTags:      d.Tags,
+	}, d.Region, keepImages)
+}
+
+func (exps Explanations) Print(w io.Writer, format outputFormat) error {
+	switch format {
+	case formatTable:
+		return exps.printText(w)
+	case formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(exps)
+	default:
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+}
+
+func (exps Explanations) printText(w io.Writer) error {
+	for i, e := range exps {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w, e.Target)
+		if e.Repository != "" {
+			fmt.Fprintf(w, "  repository: %s\n", e.Repository)
+		}
+		if e.Image == nil {
+			fmt.Fprintf(w, "  %s\n", e.Message)
+			continue
+		}
+		tags := strings.Join(e.Image.Tags, ",")
+		if tags == "" {
+			tags = untaggedStr
+		}
+		fmt.Fprintf(w, "  rule: %s\n", e.Rule)
+		fmt.Fprintf(w, "  type: %s\n", e.Image.Type)
+		fmt.Fprintf(w, "  digest: %s\n", e.Image.Digest)
+		fmt.Fprintf(w, "  tags: %s\n", tags)
+		fmt.Fprintf(w, "  pushed at: %s\n", e.Image.PushedAt.Format(time.RFC3339))
+		if !e.Image.PulledAt.IsZero() {
+			fmt.Fprintf(w, "  pulled at: %s\n", e.Image.PulledAt.Format(time.RFC3339))
+		}
+		fmt.Fprintln(w, "  decision chain:")
+		for j, c := range e.Chain {
+			fmt.Fprintf(w, "    %d. %s\n", j+1, c)
+		}
+		if len(e.Consumers) > 0 {
+			fmt.Fprintln(w, "  consumers:")
+			for _, c := range e.Consumers {
+				fmt.Fprintf(w, "    - %s\n", c)
+			}
+		}
+	}
+	return nil
+}
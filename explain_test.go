@@ -0,0 +1,131 @@
+package ecrm_test
+
+import (
+	"testing"
+
+	"github.com/fujiwara/ecrm"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExplain(t *testing.T) {
+	rcs := []*ecrm.RepositoryConfig{{NamePattern: "prod/*", Expires: "30d"}}
+	for _, rc := range rcs {
+		if err := rc.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	decisions := ecrm.ImageDecisions{
+		{Account: "012345678901", Region: "ap-northeast-1", Repo: "prod/app", Digest: "sha256:aaa", Tags: []string{"v1"}, Reason: ecrm.ReasonInUse, Rule: "name_pattern=prod/*"},
+		{Account: "012345678901", Region: "ap-northeast-1", Repo: "prod/app", Digest: "sha256:bbb", Tags: []string{}, Expired: true, Reason: ecrm.ReasonExpired},
+		{Account: "012345678901", Region: "ap-northeast-1", Repo: "prod/web", Digest: "sha256:aaa", Tags: []string{"latest"}, Reason: ecrm.ReasonKeepTagPattern},
+	}
+	keepImages := make(ecrm.Images)
+	keepImages.Add("012345678901.dkr.ecr.ap-northeast-1.amazonaws.com/prod/app:v1", "arn:aws:ecs:ap-northeast-1:012345678901:task-definition/app:1")
+	keepImages.Add("012345678901.dkr.ecr.ap-northeast-1.amazonaws.com/prod/app@sha256:aaa", "arn:aws:lambda:ap-northeast-1:012345678901:function:app:3")
+	keepImages.Add("012345678901.dkr.ecr.ap-northeast-1.amazonaws.com/prod/web:v1", "scanned.json")
+	// same repository name in other registries must not be reported as consumers
+	keepImages.Add("999999999999.dkr.ecr.ap-northeast-1.amazonaws.com/prod/app:v1", "arn:aws:ecs:ap-northeast-1:999999999999:task-definition/other:1")
+	keepImages.Add("012345678901.dkr.ecr.us-east-1.amazonaws.com/prod/app@sha256:aaa", "arn:aws:lambda:us-east-1:012345678901:function:other:1")
+
+	tests := []struct {
+		target    string
+		repos     []ecrm.RepositoryName
+		consumers [][]string
+		found     bool
+	}{
+		{
+			target: "012345678901.dkr.ecr.ap-northeast-1.amazonaws.com/prod/app:v1",
+			repos:  []ecrm.RepositoryName{"prod/app"},
+			consumers: [][]string{{
+				"arn:aws:ecs:ap-northeast-1:012345678901:task-definition/app:1",
+				"arn:aws:lambda:ap-northeast-1:012345678901:function:app:3",
+			}},
+			found: true,
+		},
+		{
+			target:    "sha256:aaa",
+			repos:     []ecrm.RepositoryName{"prod/app", "prod/web"},
+			consumers: [][]string{{"arn:aws:ecs:ap-northeast-1:012345678901:task-definition/app:1", "arn:aws:lambda:ap-northeast-1:012345678901:function:app:3"}, nil},
+			found:     true,
+		},
+		{
+			target:    "012345678901.dkr.ecr.ap-northeast-1.amazonaws.com/prod/app@sha256:bbb",
+			repos:     []ecrm.RepositoryName{"prod/app"},
+			consumers: [][]string{nil},
+			found:     true,
+		},
+		{
+			target:    "012345678901.dkr.ecr.ap-northeast-1.amazonaws.com/prod/web",
+			repos:     []ecrm.RepositoryName{"prod/web"},
+			consumers: [][]string{nil},
+			found:     true,
+		},
+		{
+			target: "012345678901.dkr.ecr.ap-northeast-1.amazonaws.com/dev/app:v1",
+			repos:  []ecrm.RepositoryName{"dev/app"},
+			found:  false,
+		},
+		{
+			target: "012345678901.dkr.ecr.ap-northeast-1.amazonaws.com/prod/app:v2",
+			repos:  []ecrm.RepositoryName{"prod/app"},
+			found:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			exps := ecrm.Explain(tt.target, rcs, decisions, keepImages)
+			if len(exps) != len(tt.repos) {
+				t.Fatalf("unexpected explanations: %d", len(exps))
+			}
+			for i, e := range exps {
+				if e.Repository != tt.repos[i] {
+					t.Errorf("unexpected repository: %s", e.Repository)
+				}
+				if (e.Image != nil) != tt.found {
+					t.Errorf("unexpected found: %s", e.Message)
+				}
+				if tt.found {
+					if diff := cmp.Diff(tt.consumers[i], e.Consumers); diff != "" {
+						t.Errorf("unexpected consumers: %s", diff)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestExplainRegistries(t *testing.T) {
+	rcs := []*ecrm.RepositoryConfig{{NamePattern: "*", Expires: "30d"}}
+	for _, rc := range rcs {
+		if err := rc.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	decisions := ecrm.ImageDecisions{
+		{Account: "123456789012", Region: "us-east-1", Repo: "app", Digest: "sha256:aaa", Tags: []string{"latest"}, Reason: ecrm.ReasonKeepTagPattern},
+		{Account: "123456789012", Region: "ap-northeast-1", Repo: "app", Digest: "sha256:bbb", Tags: []string{"latest"}, Reason: ecrm.ReasonKeepTagPattern},
+		{Account: "999999999999", Region: "us-east-1", Repo: "app", Digest: "sha256:ccc", Tags: []string{"latest"}, Reason: ecrm.ReasonKeepTagPattern},
+	}
+	tests := []struct {
+		target  string
+		digests []string
+	}{
+		{"123456789012.dkr.ecr.us-east-1.amazonaws.com/app:latest", []string{"sha256:aaa"}},
+		{"999999999999.dkr.ecr.us-east-1.amazonaws.com/app", []string{"sha256:ccc"}},
+		{"sha256:bbb", []string{"sha256:bbb"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			var digests []string
+			for _, e := range ecrm.Explain(tt.target, rcs, decisions, make(ecrm.Images)) {
+				if e.Image == nil {
+					t.Fatalf("unexpected explanation: %s", e.Message)
+				}
+				digests = append(digests, e.Image.Digest)
+			}
+			if diff := cmp.Diff(tt.digests, digests); diff != "" {
+				t.Errorf("unexpected images: %s", diff)
+			}
+		})
+	}
+}
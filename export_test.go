@@ -1,6 +1,137 @@
 package ecrm
 
+import (
+	"context"
+	"time"
+
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
 var (
-	ParseTaskdefArn  = parseTaskdefArn
-	IsKeptImageIndex = isKeptImageIndex
+	ParseTaskdefArn     = parseTaskdefArn
+	IsKeptImageIndex    = isKeptImageIndex
+	BatchDeleteImages   = batchDeleteImages
+	DeleteImagesInOrder = deleteImagesInOrder
+	IndexManifests      = indexManifests
+	CheckDeleteLimits   = checkDeleteLimits
+	MergeTargetImages   = mergeTargetImages
 )
+
+func NewTestPlanner(region string) *Planner {
+	return &Planner{region: region}
+}
+
+func (p *Planner) DecideImage(repo RepositoryName, rc *RepositoryConfig, keepImages Images, constituents, semvers map[string]string, keepCounts map[string]int64, d ecrTypes.ImageDetail) *ImageDecision {
+	return p.decideImage(repo, rc, keepImages, constituents, semvers, keepCounts, d)
+}
+
+func (c *KeepSemverConfig) KeptDigests(details []ecrTypes.ImageDetail) map[string]string {
+	return c.keptDigests(details)
+}
+
+func Explain(target string, rcs []*RepositoryConfig, decisions ImageDecisions, keepImages Images) Explanations {
+	return explain(parseExplainTarget(target), rcs, decisions, keepImages)
+}
+
+func ConsumersOf(images Images, u ImageURI) []string {
+	return images[u].sortedMembers()
+}
+
+var (
+	QuarantineTag   = quarantineTag
+	StripSystemTags = stripSystemTags
+)
+
+func (r *PlanResult) ApplySoftDelete(sc *SoftDeleteConfig, now time.Time) {
+	r.applySoftDelete(sc, now)
+}
+
+func (p *Planner) DecideArchivedImage(repo RepositoryName, rc *RepositoryConfig, keepImages Images, d ecrTypes.ImageDetail) *ImageDecision {
+	return p.decideArchivedImage(repo, rc, keepImages, d)
+}
+
+func SizeBudget(budget int64, details []ecrTypes.ImageDetail, decisions ImageDecisions, children map[string][]string) ([]int, int64) {
+	expired, kept := sizeBudget(budget, details, decisions, children)
+	var indexes []int
+	for _, e := range expired {
+		indexes = append(indexes, e.index)
+	}
+	return indexes, kept
+}
+
+func (c *Config) EffectiveRepositoryConfig(name RepositoryName) *RepositoryConfig {
+	return matchRepositoryConfig(c.repositoriesFor(""), name)
+}
+
+func ValidateConfigFile(path, resourcesFile, minExpires string) (ConfigIssues, error) {
+	var res *LintResources
+	if resourcesFile != "" {
+		var err error
+		if res, err = loadLintResources(resourcesFile); err != nil {
+			return nil, err
+		}
+	}
+	return validateConfigFile(path, res, minExpires), nil
+}
+
+func (s *Scanner) ScanKubernetes(ctx context.Context, kcs []*KubernetesConfig) error {
+	return s.scanKubernetes(ctx, kcs)
+}
+
+func NewAppRunnerScanner(client appRunnerClient) *Scanner {
+	return &Scanner{Images: make(Images), apprunner: client}
+}
+
+func (s *Scanner) ScanAppRunnerServices(ctx context.Context, acs []*AppRunnerConfig) error {
+	return s.scanAppRunnerServices(ctx, acs)
+}
+
+var (
+	BatchJobDefinitionImages = batchJobDefinitionImages
+	BatchJobImages           = batchJobImages
+)
+
+func (fs DeleteFailures) Join(err error) error {
+	return fs.join(err)
+}
+
+const (
+	FormatTable = formatTable
+	FormatJSON  = formatJSON
+)
+
+func ScheduledTaskdef(ctx context.Context, client taskdefDescriber, tdArn string) (string, error) {
+	td, err := scheduledTaskdef(ctx, client, tdArn, Consumer{})
+	return td.String(), err
+}
+
+func NewScheduledTaskScanner(eb eventBridgeClient, sc schedulerClient) *Scanner {
+	return &Scanner{Images: make(Images), eventbridge: eb, scheduler: sc}
+}
+
+// ScanScheduledTasks returns a map of the task definitions to the consumers.
+func (s *Scanner) ScanScheduledTasks(ctx context.Context, ccs []*ClusterConfig) (map[string]Consumer, error) {
+	tds, err := s.scanScheduledTasks(ctx, ccs)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]Consumer, len(tds))
+	for _, td := range tds {
+		td.usedBy.ScannedAt = time.Time{}
+		m[td.String()] = td.usedBy
+	}
+	return m, nil
+}
+
+func ServiceTaskdefs(sv ecsTypes.Service, clusterName string) ([]string, error) {
+	tds, err := serviceTaskdefs(sv, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(tds))
+	for _, td := range tds {
+		names = append(names, td.String()+" "+td.usedBy.Service+"/"+td.usedBy.Cluster)
+	}
+	return names, nil
+}
@@ -25,8 +25,8 @@ func (s *Scanner) scanExternalCommands(ctx context.Context, commands []*External
 			return err
 		}
 		imgs := make(Images)
-		src := "external_command: " + strings.Join(ext.Command, " ")
-		if err := imgs.LoadJSON(src, b); err != nil {
+		src := newConsumer(ConsumerTypeExternalCommand, strings.Join(ext.Command, " "))
+		if err := imgs.loadJSON(src, b); err != nil {
 			return err
 		}
 		s.Images.Merge(imgs)
@@ -1,6 +1,7 @@
 package ecrm
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -8,6 +9,7 @@ import (
 	"os"
 	"sort"
 	"strings"
+	"time"
 )
 
 // ImageURI represents an image URI.
@@ -44,6 +46,35 @@ func (u ImageURI) Base() string {
 	}
 }
 
+// Digest returns the digest of the image URI. It returns an empty string if the URI is not a digest URI.
+func (u ImageURI) Digest() string {
+	if !u.IsDigestURI() {
+		return ""
+	}
+	return strings.SplitN(string(u), "@", 2)[1]
+}
+
+// RepositoryName returns the repository name of the image URI (without the registry host).
+func (u ImageURI) RepositoryName() RepositoryName {
+	base := u.Base()
+	if u.IsECRImage() {
+		return RepositoryName(strings.SplitN(base, "/", 2)[1])
+	}
+	return RepositoryName(base)
+}
+
+// Registry returns the account ID and the region of the ECR image URI ({account}.dkr.ecr.{region}.amazonaws.com/...).
+// It returns empty strings if the URI is not an ECR image.
+func (u ImageURI) Registry() (account, region string) {
+	if !u.IsECRImage() {
+		return "", ""
+	}
+	host, _, _ := strings.Cut(string(u), "/")
+	account, rest, _ := strings.Cut(host, ".dkr.ecr.")
+	region, _, _ = strings.Cut(rest, ".")
+	return account, region
+}
+
 func (u ImageURI) String() string {
 	return string(u)
 }
@@ -52,8 +83,18 @@ func (u ImageURI) Short() string {
 	return strings.SplitN(string(u), "/", 2)[1]
 }
 
-type Images map[ImageURI]set
+type Images map[ImageURI]consumers
+
+const ScannedFileVersion = 2
 
+// scannedFile represents the versioned format of the scan result, that preserves consumers of each image URI.
+type scannedFile struct {
+	Version   int                     `json:"version"`
+	ScannedAt time.Time               `json:"scanned_at"`
+	Images    map[ImageURI][]Consumer `json:"images"`
+}
+
+// Print writes the image URIs as a JSON array (legacy format).
 func (i Images) Print(w io.Writer) error {
 	m := make([]string, 0, len(i))
 	for k := range i {
@@ -68,6 +109,24 @@ func (i Images) Print(w io.Writer) error {
 	return nil
 }
 
+// PrintV2 writes the image URIs with their consumers in the versioned format.
+func (i Images) PrintV2(w io.Writer, scannedAt time.Time) error {
+	f := scannedFile{
+		Version:   ScannedFileVersion,
+		ScannedAt: scannedAt,
+		Images:    make(map[ImageURI][]Consumer, len(i)),
+	}
+	for u, cs := range i {
+		f.Images[u] = cs.list()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(f); err != nil {
+		return fmt.Errorf("failed to encode image uris: %w", err)
+	}
+	return nil
+}
+
 func (i Images) LoadFile(filename string) error {
 	b, err := os.ReadFile(filename)
 	if err != nil {
@@ -76,23 +135,50 @@ func (i Images) LoadFile(filename string) error {
 	return i.LoadJSON(filename, b)
 }
 
+// LoadJSON loads image URIs from JSON in the legacy array format or the versioned format.
+// In the legacy format, src is recorded as the consumer of each image URI.
 func (i Images) LoadJSON(src string, b []byte) error {
+	return i.loadJSON(Consumer{Type: ConsumerTypeFile, Source: src}, b)
+}
+
+func (i Images) loadJSON(src Consumer, b []byte) error {
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '{' {
+		f := scannedFile{}
+		if err := json.Unmarshal(b, &f); err != nil {
+			return fmt.Errorf("failed to decode images: %w", err)
+		}
+		if f.Version != ScannedFileVersion {
+			return fmt.Errorf("unsupported scanned file version: %d", f.Version)
+		}
+		for u, cs := range f.Images {
+			log.Println("[debug] ImageUri", u, "src", src.String(), "consumers", len(cs))
+			if len(cs) == 0 {
+				cs = []Consumer{src}
+			}
+			i[u] = i[u].union(newConsumers(cs...))
+		}
+		return nil
+	}
 	in := []string{}
 	if err := json.Unmarshal(b, &in); err != nil {
 		return fmt.Errorf("failed to decode images: %w", err)
 	}
 	for _, u := range in {
-		log.Println("[debug] ImageUri", u, "src", src)
-		i[ImageURI(u)] = newSet(src)
+		log.Println("[debug] ImageUri", u, "src", src.String())
+		i[ImageURI(u)] = i[ImageURI(u)].union(newConsumers(src))
 	}
 	return nil
 }
 
 func (i Images) Add(u ImageURI, usedBy string) bool {
+	return i.AddConsumer(u, Consumer{Source: usedBy})
+}
+
+func (i Images) AddConsumer(u ImageURI, c Consumer) bool {
 	if _, ok := i[u]; !ok {
-		i[u] = newSet()
+		i[u] = make(consumers)
 	}
-	return i[u].add(usedBy)
+	return i[u].add(c)
 }
 
 func (i Images) Contains(u ImageURI) bool {
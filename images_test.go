@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/fujiwara/ecrm"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 func TestImageURI(t *testing.T) {
@@ -136,3 +138,36 @@ func TestPrintImages(t *testing.T) {
 		t.Errorf("unexpected images: %s", diff)
 	}
 }
+
+func TestLoadImagesV2(t *testing.T) {
+	images := make(ecrm.Images)
+	if err := images.LoadFile("testdata/images.json"); err != nil {
+		t.Fatal(err)
+	}
+	if err := images.LoadFile("testdata/images_v2.json"); err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 4 {
+		t.Errorf("unexpected images: %d", len(images))
+	}
+
+	b := &bytes.Buffer{}
+	if err := images.PrintV2(b, time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	restored := make(ecrm.Images)
+	if err := restored.LoadJSON("restored.json", b.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(images, restored, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("unexpected restored images: %s", diff)
+	}
+
+	u := ecrm.ImageURI("0123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/foo/bar:fe668fb9")
+	if diff := cmp.Diff([]string{
+		"arn:aws:ecs:ap-northeast-1:0123456789012:task-definition/app:12 (service:app cluster:prod)",
+		"testdata/images.json",
+	}, ecrm.ConsumersOf(images, u)); diff != "" {
+		t.Errorf("unexpected consumers: %s", diff)
+	}
+}
@@ -0,0 +1,648 @@
+package ecrm
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/samber/lo"
+)
+
+const (
+	kubernetesInCluster      = "in-cluster"
+	kubernetesServiceAccount = "/var/run/secrets/kubernetes.io/serviceaccount"
+	kubernetesListLimit      = 500
+)
+
+// KubernetesConfig is a Kubernetes cluster (e.g. Amazon EKS) to scan workloads using images.
+// The cluster is accessed by the context of the kubeconfig, or by the service account in the cluster.
+type KubernetesConfig struct {
+	Kubeconfig string   `yaml:"kubeconfig,omitempty"`
+	Context    string   `yaml:"context,omitempty"`
+	InCluster  bool     `yaml:"in_cluster,omitempty"`
+	Namespaces []string `yaml:"namespaces,omitempty"`
+
+	namespaces patterns
+}
+
+func (c *KubernetesConfig) Validate() error {
+	if c.InCluster && (c.Kubeconfig != "" || c.Context != "") {
+		return errors.New("kubernetes in_cluster is exclusive with kubeconfig and context")
+	}
+	ps, err := compilePatterns(c.Namespaces)
+	if err != nil {
+		return fmt.Errorf("kubernetes namespaces: %w", err)
+	}
+	c.namespaces = ps
+	return nil
+}
+
+func (c *KubernetesConfig) String() string {
+	if c.InCluster {
+		return kubernetesInCluster
+	}
+	if c.Context != "" {
+		return c.Context
+	}
+	return "(current context)"
+}
+
+// MatchNamespace reports whether the namespace is scanned. All namespaces are scanned when namespaces are not defined.
+func (c *KubernetesConfig) MatchNamespace(ns string) bool {
+	if len(c.Namespaces) == 0 {
+		return true
+	}
+	ps := c.namespaces
+	if ps == nil {
+		// not validated yet
+		var err error
+		if ps, err = compilePatterns(c.Namespaces); err != nil {
+			return false
+		}
+	}
+	_, matched := ps.match(ns)
+	return matched
+}
+
+// plainNamespaces returns the namespaces when all of them are plain names without wildcards, regular expressions and negations.
+func (c *KubernetesConfig) plainNamespaces() ([]string, bool) {
+	if len(c.Namespaces) == 0 {
+		return nil, false
+	}
+	for _, ns := range c.Namespaces {
+		if strings.ContainsAny(ns, "*?") ||
+			strings.HasPrefix(ns, PatternRegexpPrefix) ||
+			strings.HasPrefix(ns, PatternNegationPrefix) {
+			return nil, false
+		}
+	}
+	return lo.Uniq(c.Namespaces), true
+}
+
+// kubeconfig is a subset of the kubeconfig file.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+			TLSServerName            string `yaml:"tls-server-name"`
+			ProxyURL                 string `yaml:"proxy-url"`
+		} `yaml:"cluster"`
+
+		dir string // the directory of the kubeconfig file defining the cluster
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string        `yaml:"token"`
+			TokenFile             string        `yaml:"tokenFile"`
+			ClientCertificate     string        `yaml:"client-certificate"`
+			ClientCertificateData string        `yaml:"client-certificate-data"`
+			ClientKey             string        `yaml:"client-key"`
+			ClientKeyData         string        `yaml:"client-key-data"`
+			Exec                  *kubeExecAuth `yaml:"exec"`
+
+			// unsupported authentications
+			AuthProvider any      `yaml:"auth-provider"`
+			Username     string   `yaml:"username"`
+			Password     string   `yaml:"password"`
+			As           string   `yaml:"as"`
+			AsGroups     []string `yaml:"as-groups"`
+		} `yaml:"user"`
+
+		dir string // the directory of the kubeconfig file defining the user
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// loadKubeconfig loads and merges the kubeconfig files in the paths separated by the list separator, as kubectl does.
+// The first current-context and the first definitions of the clusters, users and contexts in the files win.
+// Missing files are ignored when multiple files are given.
+func loadKubeconfig(paths string) (*kubeconfig, error) {
+	files := filepath.SplitList(paths)
+	merged := &kubeconfig{}
+	clusters, users, contexts := newSet(), newSet(), newSet()
+	loaded := 0
+	for _, path := range files {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			if len(files) > 1 && errors.Is(err, fs.ErrNotExist) {
+				log.Printf("[debug] kubeconfig %s is not found, skipped", path)
+				continue
+			}
+			return nil, fmt.Errorf("failed to read kubeconfig: %w", err)
+		}
+		var kc kubeconfig
+		if err := yaml.Unmarshal(b, &kc); err != nil {
+			return nil, fmt.Errorf("failed to parse kubeconfig %s: %w", path, err)
+		}
+		loaded++
+		// relative paths in the kubeconfig are relative to the kubeconfig file
+		dir := filepath.Dir(path)
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		if merged.CurrentContext == "" {
+			merged.CurrentContext = kc.CurrentContext
+		}
+		for _, x := range kc.Clusters {
+			if clusters.add(x.Name) {
+				x.dir = dir
+				merged.Clusters = append(merged.Clusters, x)
+			}
+		}
+		for _, x := range kc.Users {
+			if users.add(x.Name) {
+				x.dir = dir
+				merged.Users = append(merged.Users, x)
+			}
+		}
+		for _, x := range kc.Contexts {
+			if contexts.add(x.Name) {
+				merged.Contexts = append(merged.Contexts, x)
+			}
+		}
+	}
+	if loaded == 0 {
+		return nil, fmt.Errorf("no kubeconfig is found in %s", paths)
+	}
+	return merged, nil
+}
+
+// kubeExecAuth is a credential plugin (e.g. aws eks get-token).
+type kubeExecAuth struct {
+	APIVersion string   `yaml:"apiVersion"`
+	Command    string   `yaml:"command"`
+	Args       []string `yaml:"args"`
+	Env        []struct {
+		Name  string `yaml:"name"`
+		Value string `yaml:"value"`
+	} `yaml:"env"`
+}
+
+// token runs the credential plugin and returns the token of the ExecCredential.
+// The plugin is given the ExecCredential of the apiVersion by KUBERNETES_EXEC_INFO, as kubectl does.
+func (e *kubeExecAuth) token(ctx context.Context) (string, error) {
+	if e.APIVersion == "" {
+		return "", fmt.Errorf("apiVersion of credential plugin %s is required", e.Command)
+	}
+	info, err := json.Marshal(map[string]any{
+		"apiVersion": e.APIVersion,
+		"kind":       "ExecCredential",
+		"spec":       map[string]any{"interactive": false},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode ExecCredential: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, e.Command, e.Args...)
+	cmd.Env = append(os.Environ(), "KUBERNETES_EXEC_INFO="+string(info))
+	for _, env := range e.Env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", env.Name, env.Value))
+	}
+	buf := &bytes.Buffer{}
+	cmd.Stdout = buf
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to run credential plugin %s: %w", e.Command, err)
+	}
+	var cred struct {
+		Status struct {
+			Token string `json:"token"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &cred); err != nil {
+		return "", fmt.Errorf("failed to parse the output of credential plugin %s: %w", e.Command, err)
+	}
+	if cred.Status.Token == "" {
+		return "", fmt.Errorf("credential plugin %s returned no token", e.Command)
+	}
+	return cred.Status.Token, nil
+}
+
+// kubeClient is a minimal client of the Kubernetes API to list workloads.
+type kubeClient struct {
+	name   string
+	server string
+	client *http.Client
+	token  string
+	exec   *kubeExecAuth
+}
+
+func newKubeClient(ctx context.Context, c *KubernetesConfig) (*kubeClient, error) {
+	if c.InCluster {
+		return newInClusterKubeClient()
+	}
+	path := c.Kubeconfig
+	if path == "" {
+		path = os.Getenv("KUBECONFIG")
+	}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find home directory: %w", err)
+		}
+		path = filepath.Join(home, ".kube", "config")
+	}
+	kc, err := loadKubeconfig(path)
+	if err != nil {
+		return nil, err
+	}
+	ctxName := c.Context
+	if ctxName == "" {
+		ctxName = kc.CurrentContext
+	}
+	cl := &kubeClient{name: ctxName}
+	var clusterName, userName string
+	for _, x := range kc.Contexts {
+		if x.Name == ctxName {
+			clusterName, userName = x.Context.Cluster, x.Context.User
+		}
+	}
+	if clusterName == "" {
+		return nil, fmt.Errorf("context %s is not found in kubeconfig %s", ctxName, path)
+	}
+	tlsConfig := &tls.Config{}
+	var proxy *url.URL
+	found := false
+	for _, x := range kc.Clusters {
+		if x.Name != clusterName {
+			continue
+		}
+		found = true
+		cl.server = x.Cluster.Server
+		tlsConfig.InsecureSkipVerify = x.Cluster.InsecureSkipTLSVerify
+		tlsConfig.ServerName = x.Cluster.TLSServerName
+		if x.Cluster.ProxyURL != "" {
+			if proxy, err = url.Parse(x.Cluster.ProxyURL); err != nil {
+				return nil, fmt.Errorf("invalid proxy-url of cluster %s: %w", clusterName, err)
+			}
+		}
+		ca, err := readDataOrFile(x.Cluster.CertificateAuthorityData, resolvePath(x.dir, x.Cluster.CertificateAuthority))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read certificate authority of cluster %s: %w", clusterName, err)
+		}
+		if ca != nil {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("invalid certificate authority of cluster %s", clusterName)
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("cluster %s is not found in kubeconfig %s", clusterName, path)
+	}
+	found = userName == "" // no user is anonymous
+	for _, x := range kc.Users {
+		if x.Name != userName {
+			continue
+		}
+		found = true
+		u := x.User
+		switch {
+		case u.AuthProvider != nil:
+			return nil, fmt.Errorf("auth-provider of user %s is not supported. use exec credential plugins instead", userName)
+		case u.Username != "" || u.Password != "":
+			return nil, fmt.Errorf("basic authentication of user %s is not supported", userName)
+		case u.As != "" || len(u.AsGroups) > 0:
+			return nil, fmt.Errorf("impersonation of user %s is not supported", userName)
+		}
+		dir := x.dir
+		cl.token = u.Token
+		if u.TokenFile != "" {
+			t, err := os.ReadFile(resolvePath(dir, u.TokenFile))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read token file of user %s: %w", userName, err)
+			}
+			cl.token = strings.TrimSpace(string(t))
+		}
+		cert, err := readDataOrFile(u.ClientCertificateData, resolvePath(dir, u.ClientCertificate))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client certificate of user %s: %w", userName, err)
+		}
+		key, err := readDataOrFile(u.ClientKeyData, resolvePath(dir, u.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client key of user %s: %w", userName, err)
+		}
+		if cert != nil && key != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid client certificate of user %s: %w", userName, err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+		cl.exec = u.Exec
+		if cl.exec != nil && strings.ContainsRune(cl.exec.Command, filepath.Separator) {
+			// a command name without separators is looked up in PATH
+			cl.exec.Command = resolvePath(dir, cl.exec.Command)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("user %s is not found in kubeconfig %s", userName, path)
+	}
+	cl.client = newKubeHTTPClient(tlsConfig, proxy)
+	if cl.exec != nil {
+		if cl.token, err = cl.exec.token(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return cl, nil
+}
+
+// newInClusterKubeClient creates a client by the service account of the pod running ecrm.
+func newInClusterKubeClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not defined. ecrm is not running in a cluster")
+	}
+	token, err := os.ReadFile(filepath.Join(kubernetesServiceAccount, "token"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token: %w", err)
+	}
+	ca, err := os.ReadFile(filepath.Join(kubernetesServiceAccount, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account ca.crt: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("invalid service account ca.crt")
+	}
+	return &kubeClient{
+		name:   kubernetesInCluster,
+		server: "https://" + net.JoinHostPort(host, port),
+		client: newKubeHTTPClient(&tls.Config{RootCAs: pool}, nil),
+		token:  strings.TrimSpace(string(token)),
+	}, nil
+}
+
+// newKubeHTTPClient creates a client with the TLS config. The proxy overrides the proxy from the environment if not nil.
+func newKubeHTTPClient(tlsConfig *tls.Config, proxy *url.URL) *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = tlsConfig
+	if proxy != nil {
+		tr.Proxy = http.ProxyURL(proxy)
+	}
+	return &http.Client{Transport: tr, Timeout: 60 * time.Second}
+}
+
+// resolvePath resolves the relative path against the directory.
+func resolvePath(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// readDataOrFile returns base64 decoded data, or the content of the file.
+func readDataOrFile(data, file string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file != "" {
+		return os.ReadFile(file)
+	}
+	return nil, nil
+}
+
+// kubeContainer is a container (or an init / ephemeral container) in a pod spec.
+type kubeContainer struct {
+	Name  string `json:"name"`
+	Image string `json:"image"`
+}
+
+type kubePodSpec struct {
+	Containers          []kubeContainer `json:"containers"`
+	InitContainers      []kubeContainer `json:"initContainers"`
+	EphemeralContainers []kubeContainer `json:"ephemeralContainers"`
+}
+
+func (s kubePodSpec) containers() []kubeContainer {
+	cs := make([]kubeContainer, 0, len(s.Containers)+len(s.InitContainers)+len(s.EphemeralContainers))
+	cs = append(cs, s.Containers...)
+	cs = append(cs, s.InitContainers...)
+	return append(cs, s.EphemeralContainers...)
+}
+
+type kubeContainerStatus struct {
+	Name    string `json:"name"`
+	Image   string `json:"image"`
+	ImageID string `json:"imageID"`
+}
+
+// kubeObject is a workload. The pod spec is the spec itself (Pod), in the template (Deployment, Job, etc.)
+// or in the job template (CronJob).
+type kubeObject struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec struct {
+		kubePodSpec
+		Template *struct {
+			Spec kubePodSpec `json:"spec"`
+		} `json:"template"`
+		JobTemplate *struct {
+			Spec struct {
+				Template struct {
+					Spec kubePodSpec `json:"spec"`
+				} `json:"template"`
+			} `json:"spec"`
+		} `json:"jobTemplate"`
+	} `json:"spec"`
+	Status struct {
+		ContainerStatuses          []kubeContainerStatus `json:"containerStatuses"`
+		InitContainerStatuses      []kubeContainerStatus `json:"initContainerStatuses"`
+		EphemeralContainerStatuses []kubeContainerStatus `json:"ephemeralContainerStatuses"`
+	} `json:"status"`
+}
+
+func (o *kubeObject) podSpec() kubePodSpec {
+	switch {
+	case o.Spec.JobTemplate != nil:
+		return o.Spec.JobTemplate.Spec.Template.Spec
+	case o.Spec.Template != nil:
+		return o.Spec.Template.Spec
+	}
+	return o.Spec.kubePodSpec
+}
+
+func (o *kubeObject) containerStatuses() []kubeContainerStatus {
+	var ss []kubeContainerStatus
+	ss = append(ss, o.Status.ContainerStatuses...)
+	ss = append(ss, o.Status.InitContainerStatuses...)
+	return append(ss, o.Status.EphemeralContainerStatuses...)
+}
+
+type kubeList struct {
+	Metadata struct {
+		Continue string `json:"continue"`
+	} `json:"metadata"`
+	Items []kubeObject `json:"items"`
+}
+
+// kubeWorkloadKind is a kind of workloads and the API group path and resource to list it.
+type kubeWorkloadKind struct {
+	kind     string
+	group    string
+	resource string
+}
+
+var kubeWorkloadKinds = []kubeWorkloadKind{
+	{"Pod", "/api/v1", "pods"},
+	{"Deployment", "/apis/apps/v1", "deployments"},
+	{"StatefulSet", "/apis/apps/v1", "statefulsets"},
+	{"DaemonSet", "/apis/apps/v1", "daemonsets"},
+	{"ReplicaSet", "/apis/apps/v1", "replicasets"},
+	{"Job", "/apis/batch/v1", "jobs"},
+	{"CronJob", "/apis/batch/v1", "cronjobs"},
+}
+
+// path returns the API path to list the kind in the namespace, or in all namespaces when ns is empty.
+func (k kubeWorkloadKind) path(ns string) string {
+	if ns == "" {
+		return k.group + "/" + k.resource
+	}
+	return k.group + "/namespaces/" + url.PathEscape(ns) + "/" + k.resource
+}
+
+// list lists all the objects of the path, following the continue tokens.
+func (cl *kubeClient) list(ctx context.Context, path string) ([]kubeObject, error) {
+	var objs []kubeObject
+	var cont string
+	for {
+		q := url.Values{}
+		q.Set("limit", fmt.Sprint(kubernetesListLimit))
+		if cont != "" {
+			q.Set("continue", cont)
+		}
+		u := strings.TrimSuffix(cl.server, "/") + path + "?" + q.Encode()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		if cl.token != "" {
+			req.Header.Set("Authorization", "Bearer "+cl.token)
+		}
+		resp, err := cl.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to list %s: %s %s", path, resp.Status, bytes.TrimSpace(b))
+		}
+		var l kubeList
+		if err := json.Unmarshal(b, &l); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		objs = append(objs, l.Items...)
+		if cont = l.Metadata.Continue; cont == "" {
+			return objs, nil
+		}
+	}
+}
+
+// scanKubernetes scans workloads in the Kubernetes clusters and collects ECR images in use.
+func (s *Scanner) scanKubernetes(ctx context.Context, kcs []*KubernetesConfig) error {
+	for _, kc := range kcs {
+		log.Printf("[info] scanning kubernetes cluster %s", kc)
+		cl, err := newKubeClient(ctx, kc)
+		if err != nil {
+			return fmt.Errorf("failed to create kubernetes client for %s: %w", kc, err)
+		}
+		if err := s.scanKubernetesCluster(ctx, cl, kc); err != nil {
+			return fmt.Errorf("failed to scan kubernetes cluster %s: %w", kc, err)
+		}
+	}
+	return nil
+}
+
+func (s *Scanner) scanKubernetesCluster(ctx context.Context, cl *kubeClient, kc *KubernetesConfig) error {
+	// list in each namespace for the credentials with namespace-scoped RBAC
+	namespaces, ok := kc.plainNamespaces()
+	if !ok {
+		namespaces = []string{""}
+	}
+	for _, wk := range kubeWorkloadKinds {
+		var objs []kubeObject
+		for _, ns := range namespaces {
+			o, err := cl.list(ctx, wk.path(ns))
+			if err != nil {
+				return err
+			}
+			objs = append(objs, o...)
+		}
+		for _, o := range objs {
+			if !kc.MatchNamespace(o.Metadata.Namespace) {
+				continue
+			}
+			c := newConsumer(ConsumerTypeKubernetes, fmt.Sprintf("%s/%s/%s", o.Metadata.Namespace, wk.kind, o.Metadata.Name))
+			c.Cluster = cl.name
+			add := func(u ImageURI, container string) {
+				if !u.IsECRImage() {
+					log.Printf("[debug] Skipping non ECR image %s", u)
+					return
+				}
+				if s.Images.AddConsumer(u, c) {
+					log.Printf("[info] image %s is used by %s container on %s", u, container, c)
+				}
+			}
+			for _, cn := range o.podSpec().containers() {
+				add(ImageURI(cn.Image), cn.Name)
+			}
+			// the digests resolved by the kubelet
+			for _, st := range o.containerStatuses() {
+				if u, ok := resolvedImageURI(st); ok {
+					add(u, st.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// resolvedImageURI returns the image URI with the digest from imageID of the container status.
+// imageID is a repository digest like "docker-pullable://{repository}@sha256:..." or "{repository}@sha256:...".
+func resolvedImageURI(st kubeContainerStatus) (ImageURI, bool) {
+	id := st.ImageID
+	if _, after, found := strings.Cut(id, "://"); found {
+		id = after
+	}
+	u := ImageURI(id)
+	if !u.IsDigestURI() || !u.IsECRImage() {
+		return "", false
+	}
+	return u, true
+}
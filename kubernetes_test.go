@@ -0,0 +1,324 @@
+package ecrm_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/fujiwara/ecrm"
+	"github.com/google/go-cmp/cmp"
+)
+
+const testECRHost = "012345678901.dkr.ecr.ap-northeast-1.amazonaws.com"
+
+func podSpec(containers ...string) map[string]any {
+	var cs []map[string]string
+	for i, c := range containers {
+		cs = append(cs, map[string]string{"name": fmt.Sprintf("c%d", i), "image": c})
+	}
+	return map[string]any{"containers": cs}
+}
+
+func item(ns, name string, spec map[string]any) map[string]any {
+	return map[string]any{
+		"metadata": map[string]string{"namespace": ns, "name": name},
+		"spec":     spec,
+	}
+}
+
+func newFakeKubernetesAPI() *httptest.Server {
+	lists := map[string][]map[string]any{
+		"/api/v1/pods": {
+			{
+				"metadata": map[string]string{"namespace": "default", "name": "web-abc"},
+				"spec": map[string]any{
+					"containers":     []map[string]string{{"name": "app", "image": testECRHost + "/app:v1"}},
+					"initContainers": []map[string]string{{"name": "init", "image": "busybox:latest"}},
+				},
+				"status": map[string]any{
+					"containerStatuses": []map[string]string{{
+						"name":    "app",
+						"image":   testECRHost + "/app:v1",
+						"imageID": "docker-pullable://" + testECRHost + "/app@sha256:1111",
+					}},
+				},
+			},
+			item("kube-system", "coredns", podSpec(testECRHost+"/coredns:v1")),
+		},
+		"/apis/apps/v1/deployments": {
+			{
+				"metadata": map[string]string{"namespace": "default", "name": "web"},
+				"spec":     map[string]any{"template": map[string]any{"spec": podSpec(testECRHost + "/app:v1")}},
+			},
+		},
+		"/apis/batch/v1/cronjobs": {
+			{
+				"metadata": map[string]string{"namespace": "batch", "name": "daily"},
+				"spec": map[string]any{"jobTemplate": map[string]any{"spec": map[string]any{
+					"template": map[string]any{"spec": podSpec(testECRHost + "/batch:v2")},
+				}}},
+			},
+		},
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		items := lists[r.URL.Path]
+		// split into pages of one item
+		var page []map[string]any
+		var cont string
+		start := 0
+		fmt.Sscanf(r.URL.Query().Get("continue"), "%d", &start)
+		if start < len(items) {
+			page = items[start : start+1]
+			if start+1 < len(items) {
+				cont = fmt.Sprint(start + 1)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"metadata": map[string]string{"continue": cont},
+			"items":    page,
+		})
+	}))
+}
+
+// writeKubeconfig writes a kubeconfig of the server with the user into the directory.
+func writeKubeconfig(t *testing.T, dir, server, user string) string {
+	t.Helper()
+	kubeconfig := filepath.Join(dir, "config")
+	err := os.WriteFile(kubeconfig, []byte(fmt.Sprintf(`
+current-context: test
+clusters:
+  - name: test
+    cluster:
+      server: %s
+users:
+  - name: test
+    user:
+%s
+contexts:
+  - name: test
+    context:
+      cluster: test
+      user: test
+`, server, user)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kubeconfig
+}
+
+func TestScanKubernetes(t *testing.T) {
+	ts := newFakeKubernetesAPI()
+	defer ts.Close()
+	kubeconfig := writeKubeconfig(t, t.TempDir(), ts.URL, "      token: test-token")
+	kc := &ecrm.KubernetesConfig{Kubeconfig: kubeconfig, Namespaces: []string{"!kube-system"}}
+	if err := kc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	s := ecrm.NewScanner(aws.Config{})
+	if err := s.ScanKubernetes(t.Context(), []*ecrm.KubernetesConfig{kc}); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[ecrm.ImageURI][]string{
+		testECRHost + "/app:v1": {
+			"kubernetes: default/Deployment/web (cluster:test)",
+			"kubernetes: default/Pod/web-abc (cluster:test)",
+		},
+		testECRHost + "/app@sha256:1111": {
+			"kubernetes: default/Pod/web-abc (cluster:test)",
+		},
+		testECRHost + "/batch:v2": {
+			"kubernetes: batch/CronJob/daily (cluster:test)",
+		},
+	}
+	actual := make(map[ecrm.ImageURI][]string)
+	for u := range s.Images {
+		actual[u] = ecrm.ConsumersOf(s.Images, u)
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("unexpected images (-want +got):\n%s", diff)
+	}
+}
+
+func TestScanKubernetesNamespaceScoped(t *testing.T) {
+	lists := map[string][]map[string]any{
+		"/api/v1/namespaces/default/pods":          {item("default", "web-abc", podSpec(testECRHost+"/app:v1"))},
+		"/apis/batch/v1/namespaces/batch/cronjobs": {item("batch", "daily", podSpec(testECRHost+"/batch:v2"))},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// namespace-scoped RBAC forbids listing in all namespaces
+		if !strings.Contains(r.URL.Path, "/namespaces/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"items": lists[r.URL.Path]})
+	}))
+	defer ts.Close()
+	kubeconfig := writeKubeconfig(t, t.TempDir(), ts.URL, "      token: test-token")
+	kc := &ecrm.KubernetesConfig{Kubeconfig: kubeconfig, Namespaces: []string{"default", "batch"}}
+	if err := kc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	s := ecrm.NewScanner(aws.Config{})
+	if err := s.ScanKubernetes(t.Context(), []*ecrm.KubernetesConfig{kc}); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[ecrm.ImageURI][]string{
+		testECRHost + "/app:v1":   {"kubernetes: default/Pod/web-abc (cluster:test)"},
+		testECRHost + "/batch:v2": {"kubernetes: batch/CronJob/daily (cluster:test)"},
+	}
+	actual := make(map[ecrm.ImageURI][]string)
+	for u := range s.Images {
+		actual[u] = ecrm.ConsumersOf(s.Images, u)
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("unexpected images (-want +got):\n%s", diff)
+	}
+
+	// patterns need to list in all namespaces
+	kc = &ecrm.KubernetesConfig{Kubeconfig: kubeconfig, Namespaces: []string{"default", "batch-*"}}
+	if err := kc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ecrm.NewScanner(aws.Config{}).ScanKubernetes(t.Context(), []*ecrm.KubernetesConfig{kc}); err == nil {
+		t.Error("expected forbidden error")
+	}
+}
+
+func TestKubernetesUsers(t *testing.T) {
+	ts := newFakeKubernetesAPI()
+	defer ts.Close()
+	tests := []struct {
+		name  string
+		user  string
+		files map[string]string
+		ok    bool
+	}{
+		{
+			name:  "token file relative to kubeconfig",
+			user:  "      tokenFile: secrets/token",
+			files: map[string]string{"secrets/token": "test-token\n"},
+			ok:    true,
+		},
+		{
+			name: "exec plugin relative to kubeconfig with KUBERNETES_EXEC_INFO",
+			user: `      exec:
+        apiVersion: client.authentication.k8s.io/v1beta1
+        command: ./get-token.sh`,
+			files: map[string]string{"get-token.sh": `#!/bin/sh
+case "$KUBERNETES_EXEC_INFO" in
+  *'"apiVersion":"client.authentication.k8s.io/v1beta1"'*) echo '{"status":{"token":"test-token"}}' ;;
+  *) exit 1 ;;
+esac
+`},
+			ok: true,
+		},
+		{
+			name: "exec plugin without apiVersion",
+			user: `      exec:
+        command: ./get-token.sh`,
+			files: map[string]string{"get-token.sh": "#!/bin/sh\necho '{\"status\":{\"token\":\"test-token\"}}'\n"},
+			ok:    false,
+		},
+		{
+			name: "auth-provider is not supported",
+			user: `      token: test-token
+      auth-provider:
+        name: oidc`,
+			ok: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0700); err != nil {
+					t.Fatal(err)
+				}
+			}
+			kc := &ecrm.KubernetesConfig{Kubeconfig: writeKubeconfig(t, dir, ts.URL, tt.user)}
+			err := ecrm.NewScanner(aws.Config{}).ScanKubernetes(t.Context(), []*ecrm.KubernetesConfig{kc})
+			if tt.ok && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if !tt.ok && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestKubernetesUserNotFound(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	err := os.WriteFile(kubeconfig, []byte(`
+current-context: test
+clusters:
+  - name: test
+    cluster:
+      server: https://127.0.0.1:6443
+users:
+  - name: other
+    user:
+      token: test-token
+contexts:
+  - name: test
+    context:
+      cluster: test
+      user: test
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kc := &ecrm.KubernetesConfig{Kubeconfig: kubeconfig}
+	err = ecrm.NewScanner(aws.Config{}).ScanKubernetes(t.Context(), []*ecrm.KubernetesConfig{kc})
+	if err == nil || !strings.Contains(err.Error(), "user test is not found") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestKubernetesMergedKubeconfig(t *testing.T) {
+	ts := newFakeKubernetesAPI()
+	defer ts.Close()
+	dir := t.TempDir()
+	// the first definitions of the user and current-context win
+	first := filepath.Join(dir, "first")
+	err := os.WriteFile(first, []byte(`
+current-context: test
+users:
+  - name: test
+    user:
+      token: test-token
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := writeKubeconfig(t, dir, ts.URL, "      token: invalid-token")
+	t.Setenv("KUBECONFIG", strings.Join([]string{first, filepath.Join(dir, "missing"), second}, string(filepath.ListSeparator)))
+
+	s := ecrm.NewScanner(aws.Config{})
+	if err := s.ScanKubernetes(t.Context(), []*ecrm.KubernetesConfig{{}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Images) == 0 {
+		t.Error("no images are scanned")
+	}
+}
+
+func TestKubernetesConfigValidate(t *testing.T) {
+	kc := &ecrm.KubernetesConfig{InCluster: true, Context: "test"}
+	if err := kc.Validate(); err == nil {
+		t.Error("in_cluster and context are exclusive")
+	}
+}
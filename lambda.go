@@ -90,7 +90,9 @@ func (s *Scanner) scanLambdaFunctionArn(ctx context.Context, functionArn string,
 		return nil
 	}
 	log.Println("[debug] ImageUri", u)
-	if s.Images.Add(u, functionArn) {
+	c := newConsumer(ConsumerTypeLambdaFunction, functionArn)
+	c.Aliases = aliasNames
+	if s.Images.AddConsumer(u, c) {
 		if len(aliasNames) == 0 {
 			log.Printf("[info] %s is in use by Lambda function %s", u.String(), functionArn)
 		} else {
@@ -0,0 +1,74 @@
+package ecrm
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/samber/lo"
+)
+
+func validateDeleteLimit(maxCount int64, maxRatio float64) error {
+	if maxCount < 0 {
+		return errors.New("max_delete_count must not be negative")
+	}
+	if maxRatio < 0 || maxRatio > 1 {
+		return errors.New("max_delete_ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// exceedsDeleteLimit reports whether deleting expired images of total exceeds max_delete_count or max_delete_ratio.
+// Zero values mean no limits.
+func exceedsDeleteLimit(maxCount int64, maxRatio float64, expired, total int64) (string, bool) {
+	if maxCount > 0 && expired > maxCount {
+		return fmt.Sprintf("%d images exceed max_delete_count %d", expired, maxCount), true
+	}
+	if maxRatio > 0 && total > 0 && float64(expired)/float64(total) > maxRatio {
+		return fmt.Sprintf("%d of %d images (%.1f%%) exceed max_delete_ratio %g", expired, total, float64(expired)/float64(total)*100, maxRatio), true
+	}
+	return "", false
+}
+
+// checkDeleteLimits checks the plans do not exceed max_delete_count and max_delete_ratio
+// of the whole run and of each repository. Images to be archived are counted as deleted images.
+func checkDeleteLimits(c *Config, results PlanResults) error {
+	var errs []error
+	var expired, total int64
+	for _, r := range results {
+		rcs := c.repositoriesFor(r.Registry)
+		counts := make(map[RepositoryName]*struct{ expired, total int64 })
+		for _, s := range r.Summary {
+			if counts[s.Repo] == nil {
+				counts[s.Repo] = &struct{ expired, total int64 }{}
+			}
+			counts[s.Repo].expired += s.ExpiredImages + s.ArchivedImages
+			counts[s.Repo].total += s.TotalImages
+			expired += s.ExpiredImages + s.ArchivedImages
+			total += s.TotalImages
+		}
+		repos := lo.Keys(counts)
+		slices.Sort(repos)
+		for _, repo := range repos {
+			rc := matchRepositoryConfig(rcs, repo)
+			if rc == nil {
+				continue
+			}
+			n := counts[repo]
+			if msg, exceeded := exceedsDeleteLimit(rc.MaxDeleteCount, rc.MaxDeleteRatio, n.expired, n.total); exceeded {
+				errs = append(errs, fmt.Errorf("repository %s in %s/%s: %s", repo, r.Account, r.Region, msg))
+			}
+		}
+	}
+	if msg, exceeded := exceedsDeleteLimit(c.MaxDeleteCount, c.MaxDeleteRatio, expired, total); exceeded {
+		errs = append(errs, fmt.Errorf("total: %s", msg))
+	}
+	for _, err := range errs {
+		log.Printf("[error] %s", err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("the plan exceeds the delete limits, aborted: %w", errors.Join(errs...))
+	}
+	return nil
+}
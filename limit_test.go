@@ -0,0 +1,75 @@
+package ecrm_test
+
+import (
+	"testing"
+
+	"github.com/fujiwara/ecrm"
+)
+
+func TestCheckDeleteLimits(t *testing.T) {
+	results := ecrm.PlanResults{
+		{
+			Summary: ecrm.SummaryTable{
+				{Repo: "app", Type: ecrm.SummaryTypeImage, ExpiredImages: 8, TotalImages: 10},
+				{Repo: "app", Type: ecrm.SummaryTypeImageIndex, ExpiredImages: 0, TotalImages: 2},
+				{Repo: "batch", Type: ecrm.SummaryTypeImage, ExpiredImages: 3, TotalImages: 20},
+				{Repo: "archive", Type: ecrm.SummaryTypeImage, ArchivedImages: 3, TotalImages: 4},
+			},
+		},
+	}
+	tests := []struct {
+		name   string
+		config *ecrm.Config
+		ok     bool
+	}{
+		{
+			name:   "no limits",
+			config: &ecrm.Config{Repositories: []*ecrm.RepositoryConfig{{NamePattern: "*"}}},
+			ok:     true,
+		},
+		{
+			name:   "total count",
+			config: &ecrm.Config{MaxDeleteCount: 10, Repositories: []*ecrm.RepositoryConfig{{NamePattern: "*"}}},
+			ok:     false,
+		},
+		{
+			name:   "total ratio",
+			config: &ecrm.Config{MaxDeleteRatio: 0.5, Repositories: []*ecrm.RepositoryConfig{{NamePattern: "*"}}},
+			ok:     true,
+		},
+		{
+			name: "repository ratio",
+			config: &ecrm.Config{Repositories: []*ecrm.RepositoryConfig{
+				{Name: "app", MaxDeleteRatio: 0.5},
+				{NamePattern: "*"},
+			}},
+			ok: false,
+		},
+		{
+			name: "repository count",
+			config: &ecrm.Config{Repositories: []*ecrm.RepositoryConfig{
+				{Name: "app", MaxDeleteCount: 8},
+				{NamePattern: "*", MaxDeleteCount: 3},
+			}},
+			ok: true,
+		},
+		{
+			name: "archived ratio",
+			config: &ecrm.Config{Repositories: []*ecrm.RepositoryConfig{
+				{Name: "archive", MaxDeleteRatio: 0.5},
+				{NamePattern: "*"},
+			}},
+			ok: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ecrm.CheckDeleteLimits(tt.config, results)
+			if tt.ok && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if !tt.ok && err == nil {
+				t.Error("should be errored")
+			}
+		})
+	}
+}
@@ -0,0 +1,437 @@
+package ecrm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/fujiwara/ecrm/wildcard"
+	"github.com/goccy/go-yaml"
+	"github.com/k1LoW/duration"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Checks of config validate.
+const (
+	CheckValidation   = "validation"
+	CheckShadowed     = "shadowed"
+	CheckRedundant    = "redundant"
+	CheckUnmatched    = "unmatched"
+	CheckShortExpires = "short_expires"
+	CheckDeprecated   = "deprecated"
+)
+
+// defaultMinExpires is the shortest expires that config validate accepts without warnings, when --min-expires is not given.
+const defaultMinExpires = "7d"
+
+// ConfigIssue is a problem of the config found by config validate.
+type ConfigIssue struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	Path     string `json:"path,omitempty"`
+	Message  string `json:"message"`
+}
+
+func (i ConfigIssue) String() string {
+	if i.Path == "" {
+		return fmt.Sprintf("%s: %s (%s)", i.Severity, i.Message, i.Check)
+	}
+	return fmt.Sprintf("%s: %s: %s (%s)", i.Severity, i.Path, i.Message, i.Check)
+}
+
+// ConfigIssues is a list of ConfigIssue.
+type ConfigIssues []ConfigIssue
+
+// Errors returns the number of errors.
+func (is ConfigIssues) Errors() int {
+	var n int
+	for _, i := range is {
+		if i.Severity == SeverityError {
+			n++
+		}
+	}
+	return n
+}
+
+// Print prints the issues. The github format prints workflow commands for GitHub Actions annotations.
+func (is ConfigIssues) Print(w io.Writer, format outputFormat, path string) error {
+	switch format {
+	case formatJSON:
+		if is == nil {
+			is = ConfigIssues{}
+		}
+		b, err := json.MarshalIndent(is, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal issues: %w", err)
+		}
+		_, err = w.Write(append(b, '\n'))
+		return err
+	case formatGitHub:
+		for _, i := range is {
+			fmt.Fprintf(w, "::%s file=%s,title=ecrm %s::%s\n", i.Severity, path, i.Check, githubEscape(i.Path, i.Message))
+		}
+	default:
+		for _, i := range is {
+			fmt.Fprintln(w, i.String())
+		}
+	}
+	return nil
+}
+
+// githubEscape escapes the message of the workflow command.
+func githubEscape(path, msg string) string {
+	if path != "" {
+		msg = path + ": " + msg
+	}
+	r := []rune{}
+	for _, c := range msg {
+		switch c {
+		case '%':
+			r = append(r, []rune("%25")...)
+		case '\r':
+			r = append(r, []rune("%0D")...)
+		case '\n':
+			r = append(r, []rune("%0A")...)
+		default:
+			r = append(r, c)
+		}
+	}
+	return string(r)
+}
+
+// LintResources are the names of existing resources to find patterns matching no resources.
+// nil means unknown, and the patterns of the kind are not checked.
+type LintResources struct {
+	Repositories           []string `json:"repositories"`
+	Clusters               []string `json:"clusters"`
+	TaskDefinitionFamilies []string `json:"task_definition_families"`
+	LambdaFunctions        []string `json:"lambda_functions"`
+}
+
+func loadLintResources(path string) (*LintResources, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	res := &LintResources{}
+	if err := json.Unmarshal(b, res); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return res, nil
+}
+
+func fetchLintResources(ctx context.Context, cfg aws.Config) (*LintResources, error) {
+	res := &LintResources{
+		Repositories:           []string{},
+		Clusters:               []string{},
+		TaskDefinitionFamilies: []string{},
+		LambdaFunctions:        []string{},
+	}
+	repos, err := ecrRepositories(ctx, ecr.NewFromConfig(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe repositories: %w", err)
+	}
+	for _, r := range repos {
+		res.Repositories = append(res.Repositories, aws.ToString(r.RepositoryName))
+	}
+	ecsSvc := ecs.NewFromConfig(cfg)
+	clusters, err := clusterArns(ctx, ecsSvc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clusters: %w", err)
+	}
+	for _, a := range clusters {
+		res.Clusters = append(res.Clusters, clusterArnToName(a))
+	}
+	families, err := taskDefinitionFamilies(ctx, ecsSvc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list task definition families: %w", err)
+	}
+	for _, f := range families {
+		res.TaskDefinitionFamilies = append(res.TaskDefinitionFamilies, arnToName(f, ""))
+	}
+	fns, err := lambdaFunctions(ctx, lambda.NewFromConfig(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list lambda functions: %w", err)
+	}
+	for _, fn := range fns {
+		res.LambdaFunctions = append(res.LambdaFunctions, aws.ToString(fn.FunctionName))
+	}
+	return res, nil
+}
+
+// validateConfigFile validates the config file and lints it.
+// Unlike LoadConfig, it reports all the validation errors and lint warnings as issues.
+func validateConfigFile(path string, res *LintResources, minExpires string) ConfigIssues {
+	c, err := readConfig(path)
+	if err != nil {
+		return ConfigIssues{{Severity: SeverityError, Check: CheckValidation, Message: err.Error()}}
+	}
+	var issues ConfigIssues
+	if err := c.Validate(); err != nil {
+		errs := []error{err}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			errs = joined.Unwrap()
+		}
+		for _, e := range errs {
+			issues = append(issues, ConfigIssue{Severity: SeverityError, Check: CheckValidation, Message: e.Error()})
+		}
+	}
+	return append(issues, lintConfig(c, res, minExpires)...)
+}
+
+// lintConfig finds problems of the config that are valid but probably not intended.
+func lintConfig(c *Config, res *LintResources, minExpires string) ConfigIssues {
+	var issues ConfigIssues
+	warn := func(check, path, format string, args ...any) {
+		issues = append(issues, ConfigIssue{
+			Severity: SeverityWarning,
+			Check:    check,
+			Path:     path,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	// rules shadowed by earlier rules
+	for j, b := range c.Repositories {
+		if msg, shadowed := repositoryShadowedBy(c.Repositories[:j], b); shadowed {
+			warn(CheckShadowed, fmt.Sprintf("repositories[%d]", j), "%s %s", b, msg)
+		}
+	}
+	lintFirstMatch := func(section string, names, patterns []string) {
+		for j := range names {
+			for i := range j {
+				if covers(names[i], patterns[i], names[j], patterns[j]) {
+					warn(CheckShadowed, fmt.Sprintf("%s[%d]", section, j),
+						"%s%s is never applied because %s[%d] %s%s matches first",
+						names[j], patterns[j], section, i, names[i], patterns[i])
+					break
+				}
+			}
+		}
+	}
+	// clusters have no settings per entry, so an entry covered by an earlier one is just redundant
+	clusterNames, clusterPatterns := namesOf(c.Clusters, func(cc *ClusterConfig) (string, string) { return cc.Name, cc.NamePattern })
+	for j := range clusterNames {
+		for i := range j {
+			if covers(clusterNames[i], clusterPatterns[i], clusterNames[j], clusterPatterns[j]) {
+				warn(CheckRedundant, fmt.Sprintf("clusters[%d]", j),
+					"%s%s is redundant because clusters[%d] %s%s matches it too",
+					clusterNames[j], clusterPatterns[j], i, clusterNames[i], clusterPatterns[i])
+				break
+			}
+		}
+	}
+	taskdefNames, taskdefPatterns := namesOf(c.TaskDefinitions, func(tc *TaskdefConfig) (string, string) { return tc.Name, tc.NamePattern })
+	lintFirstMatch("task_definitions", taskdefNames, taskdefPatterns)
+	lambdaNames, lambdaPatterns := namesOf(c.LambdaFunctions, func(lc *LambdaConfig) (string, string) { return lc.Name, lc.NamePattern })
+	lintFirstMatch("lambda_functions", lambdaNames, lambdaPatterns)
+
+	// patterns matching no existing resources
+	if res != nil {
+		lintUnmatched := func(section, kind string, existing, names, patterns []string, skip func(int) bool) {
+			if existing == nil {
+				return
+			}
+			for i := range names {
+				if skip != nil && skip(i) {
+					continue
+				}
+				if !slices.ContainsFunc(existing, func(e string) bool {
+					return names[i] == e || matchPattern(nil, patterns[i], e)
+				}) {
+					warn(CheckUnmatched, fmt.Sprintf("%s[%d]", section, i), "%s%s matches no existing %s", names[i], patterns[i], kind)
+				}
+			}
+		}
+		names, patterns := namesOf(c.Repositories, func(rc *RepositoryConfig) (string, string) { return string(rc.Name), rc.NamePattern })
+		// existing repositories are of the default registry only
+		lintUnmatched("repositories", "repository", res.Repositories, names, patterns, func(i int) bool {
+			return len(c.Repositories[i].Registries) > 0
+		})
+		lintUnmatched("clusters", "cluster", res.Clusters, clusterNames, clusterPatterns, nil)
+		lintUnmatched("task_definitions", "task definition family", res.TaskDefinitionFamilies, taskdefNames, taskdefPatterns, nil)
+		lintUnmatched("lambda_functions", "function", res.LambdaFunctions, lambdaNames, lambdaPatterns, nil)
+	}
+
+	// expires shorter than a deployment cycle
+	// an invalid min expires (validated by the caller) disables the check
+	minDuration, _ := duration.Parse(minExpires)
+	lintExpires := func(path, field, expires string) {
+		if expires == "" {
+			return
+		}
+		if d, err := duration.Parse(expires); err == nil && d < minDuration {
+			warn(CheckShortExpires, path, "%s %s is shorter than %s. Images pushed but not deployed yet may be expired", field, expires, minExpires)
+		}
+	}
+	if c.Defaults != nil {
+		lintExpires("defaults", "expires", c.Defaults.Expires)
+	}
+	for i, rc := range c.Repositories {
+		path := fmt.Sprintf("repositories[%d]", i)
+		lintExpires(path, "expires", rc.Expires)
+		if rc.Streams != nil {
+			lintExpires(path, "streams expires", rc.Streams.Expires)
+		}
+	}
+
+	// deprecated fields
+	for i, lc := range c.LambdaFunctions {
+		if lc.KeepAliase != nil {
+			warn(CheckDeprecated, fmt.Sprintf("lambda_functions[%d]", i), "keep_aliase is obsoleted. All aliased versions are always kept")
+		}
+	}
+	return issues
+}
+
+func namesOf[T any](cs []T, f func(T) (string, string)) ([]string, []string) {
+	names, patterns := make([]string, len(cs)), make([]string, len(cs))
+	for i, c := range cs {
+		names[i], patterns[i] = f(c)
+	}
+	return names, patterns
+}
+
+// covers reports whether every name matched by b (name or name_pattern) is also matched by a.
+// It is a heuristic; a regexp or negated pattern of a is regarded as covering b only for the same pattern.
+func covers(aName, aPattern, bName, bPattern string) bool {
+	if bName != "" {
+		return aName == bName || matchPattern(nil, aPattern, bName)
+	}
+	if bPattern == "" || aPattern == "" {
+		return false
+	}
+	if aPattern == bPattern {
+		return true
+	}
+	a, err := compilePattern(aPattern)
+	if err != nil || a.re != nil || a.negate {
+		return false
+	}
+	if a.glob == "*" {
+		return true
+	}
+	b, err := compilePattern(bPattern)
+	if err != nil || b.re != nil || b.negate {
+		return false
+	}
+	// the wildcards of b are matched as literal characters by a
+	return wildcard.Match(a.glob, b.glob)
+}
+
+// repositoryShadowedBy reports whether the rule b never takes effect because of the earlier rules.
+// b is shadowed when an earlier rule covering b stops, or the earlier rules covering b already set all the fields that b sets.
+func repositoryShadowedBy(earlier []*RepositoryConfig, b *RepositoryConfig) (string, bool) {
+	var effective *RepositoryConfig
+	for i, a := range earlier {
+		if !registriesCover(a.Registries, b.Registries) || !covers(string(a.Name), a.NamePattern, string(b.Name), b.NamePattern) {
+			continue
+		}
+		if a.Stop {
+			return fmt.Sprintf("is never applied because repositories[%d] %s matches first and stops", i, a), true
+		}
+		if effective == nil {
+			e := *a
+			effective = &e
+		} else {
+			effective.inherit(a)
+		}
+	}
+	if effective == nil {
+		return "", false
+	}
+	// a field written in b with a zero value takes effect by overriding the following rules and the defaults
+	for _, k := range b.keys.members() {
+		switch k {
+		case "name", "name_pattern", "registries", "stop":
+			continue
+		}
+		if !effective.keys.contains(k) {
+			return "", false
+		}
+	}
+	before, err := yaml.Marshal(effective)
+	if err != nil {
+		log.Println("[debug] failed to marshal config:", err)
+		return "", false
+	}
+	effective.inherit(b)
+	after, err := yaml.Marshal(effective)
+	if err != nil {
+		log.Println("[debug] failed to marshal config:", err)
+		return "", false
+	}
+	if string(before) == string(after) {
+		return "has no effect because all of its fields are set by the earlier matching rules", true
+	}
+	return "", false
+}
+
+// registriesCover reports whether the registries of a rule include all the registries of b.
+func registriesCover(a, b []string) bool {
+	if len(a) == 0 {
+		return true
+	}
+	if len(b) == 0 {
+		return false
+	}
+	for _, r := range b {
+		if !slices.Contains(a, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateConfig validates and lints the config file, and prints the issues.
+// It returns an error when the config has validation errors (or warnings with FailOnWarning).
+func (app *App) ValidateConfig(ctx context.Context, path string, opt *Option) error {
+	if opt.MinExpires == "" {
+		opt.MinExpires = defaultMinExpires
+	}
+	if _, err := duration.Parse(opt.MinExpires); err != nil {
+		return fmt.Errorf("invalid min-expires: %w", err)
+	}
+	var res *LintResources
+	var err error
+	switch {
+	case opt.ResourcesFile != "":
+		if res, err = loadLintResources(opt.ResourcesFile); err != nil {
+			return fmt.Errorf("failed to load resources: %w", err)
+		}
+	case opt.Live:
+		if res, err = fetchLintResources(ctx, app.awsCfg); err != nil {
+			return fmt.Errorf("failed to fetch resources: %w", err)
+		}
+	}
+	issues := validateConfigFile(path, res, opt.MinExpires)
+
+	w, err := opt.OutputWriter()
+	if err != nil {
+		return fmt.Errorf("failed to open output: %w", err)
+	}
+	defer w.Close()
+	if err := issues.Print(w, opt.Format, path); err != nil {
+		return fmt.Errorf("failed to print issues: %w", err)
+	}
+	if n := issues.Errors(); n > 0 {
+		return fmt.Errorf("config has %d errors", n)
+	}
+	if opt.FailOnWarning && len(issues) > 0 {
+		return fmt.Errorf("config has %d warnings", len(issues))
+	}
+	log.Printf("[info] %s is valid (%d warnings)", path, len(issues))
+	return nil
+}
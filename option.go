@@ -7,17 +7,39 @@ import (
 )
 
 type Option struct {
-	ScanOnly     bool
-	Scan         bool
-	Delete       bool
-	Force        bool
-	Repository   RepositoryName
-	OutputFile   string
-	Format       outputFormat
-	ScannedFiles []string
+	ScanOnly      bool
+	Scan          bool
+	Delete        bool
+	Apply         bool
+	Force         bool
+	Repository    RepositoryName
+	Registry      string
+	OutputFile    string
+	Format        outputFormat
+	ScannedFiles  []string
+	PlanFile      string
+	Detail        bool
+	ExplainImages []string
+
+	ResourcesFile string
+	Live          bool
+	MinExpires    string
+	FailOnWarning bool
+
+	ScanFormatVersion int
 }
 
 func (opt *Option) Validate() error {
+	if opt.Apply {
+		// the scan inputs and the repository are taken from the plan file
+		if opt.PlanFile == "" {
+			return fmt.Errorf("no plan file provided")
+		}
+		if opt.ScanOnly || opt.Repository != "" || len(opt.ScannedFiles) > 0 {
+			return fmt.Errorf("apply cannot be combined with scan options or --repository. they are recorded in the plan file")
+		}
+		return nil
+	}
 	if len(opt.ScannedFiles) == 0 && !opt.Scan {
 		return fmt.Errorf("no --scanned-files and --no-scan provided. specify at least one")
 	}
@@ -0,0 +1,115 @@
+package ecrm
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/fujiwara/ecrm/wildcard"
+)
+
+const (
+	// PatternRegexpPrefix is the prefix of patterns written in regular expressions.
+	PatternRegexpPrefix = "re:"
+	// PatternNegationPrefix is the prefix of patterns that exclude matched names.
+	PatternNegationPrefix = "!"
+)
+
+// pattern is a compiled name pattern.
+//
+// A pattern is a wildcard pattern (supports `*` and `?`) by default.
+// A pattern prefixed by "re:" is a regular expression, and a pattern prefixed by "!" is negated.
+// e.g. "prod/*", "re:^v\d+\.\d+\.\d+$", "!*-sandbox", "!re:^tmp-"
+type pattern struct {
+	raw    string
+	negate bool
+	re     *regexp.Regexp
+	glob   string
+}
+
+func compilePattern(s string) (*pattern, error) {
+	p := &pattern{raw: s}
+	body, negate := strings.CutPrefix(s, PatternNegationPrefix)
+	p.negate = negate
+	if expr, isRegexp := strings.CutPrefix(body, PatternRegexpPrefix); isRegexp {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", s, err)
+		}
+		p.re = re
+	} else {
+		p.glob = body
+	}
+	return p, nil
+}
+
+func (p *pattern) String() string {
+	return p.raw
+}
+
+// Match reports whether the name matches the pattern (or does not match the negated pattern).
+func (p *pattern) Match(name string) bool {
+	var matched bool
+	if p.re != nil {
+		matched = p.re.MatchString(name)
+	} else {
+		matched = wildcard.Match(p.glob, name)
+	}
+	return matched != p.negate
+}
+
+// matchPattern reports whether the name matches the pattern compiled by Validate.
+// When the pattern is not compiled yet (Validate is not called), the raw pattern is compiled on the fly.
+func matchPattern(compiled *pattern, raw string, name string) bool {
+	if raw == "" {
+		return false
+	}
+	if compiled == nil {
+		p, err := compilePattern(raw)
+		if err != nil {
+			return false
+		}
+		compiled = p
+	}
+	return compiled.Match(name)
+}
+
+// patterns is a list of compiled patterns.
+// Names match the list when they match any of the positive patterns (or there are no positive patterns)
+// and are not excluded by any of the negated patterns.
+type patterns []*pattern
+
+func compilePatterns(ss []string) (patterns, error) {
+	ps := make(patterns, 0, len(ss))
+	for _, s := range ss {
+		p, err := compilePattern(s)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// match returns the positive pattern that matches the name.
+// When there are no positive patterns, it returns the first negated pattern that the name passes.
+func (ps patterns) match(name string) (*pattern, bool) {
+	var matched *pattern
+	var hasPositive bool
+	for _, p := range ps {
+		if p.negate {
+			if !p.Match(name) {
+				return nil, false
+			}
+			continue
+		}
+		hasPositive = true
+		if matched == nil && p.Match(name) {
+			matched = p
+		}
+	}
+	if !hasPositive && len(ps) > 0 {
+		return ps[0], true
+	}
+	return matched, matched != nil
+}
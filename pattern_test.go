@@ -0,0 +1,82 @@
+package ecrm_test
+
+import (
+	"testing"
+
+	"github.com/fujiwara/ecrm"
+)
+
+func TestRepositoryConfigMatchName(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    ecrm.RepositoryName
+		matched bool
+	}{
+		{"prod/*", "prod/app", true},
+		{"prod/*", "dev/app", false},
+		{"!*-sandbox", "prod/app", true},
+		{"!*-sandbox", "prod/app-sandbox", false},
+		{`re:^(prod|stg)/`, "stg/app", true},
+		{`re:^(prod|stg)/`, "dev/app", false},
+		{`!re:^tmp-`, "tmp-app", false},
+		{`!re:^tmp-`, "app", true},
+	}
+	for _, tt := range tests {
+		rc := &ecrm.RepositoryConfig{NamePattern: tt.pattern, Expires: "30d"}
+		if err := rc.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if got := rc.MatchName(tt.name); got != tt.matched {
+			t.Errorf("%s matches %s: expected %v, got %v", tt.pattern, tt.name, tt.matched, got)
+		}
+	}
+}
+
+func TestRepositoryConfigMatchTag(t *testing.T) {
+	rc := &ecrm.RepositoryConfig{
+		Name:            "app",
+		Expires:         "30d",
+		KeepTagPatterns: []string{`re:^v\d+\.\d+\.\d+$`, "release-*", "!release-*-rc"},
+	}
+	if err := rc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		tag     string
+		matched bool
+	}{
+		{"v1.2.3", true},
+		{"v1.2.3-rc1", false},
+		{"release-1", true},
+		{"release-1-rc", false},
+		{"latest", false},
+	}
+	for _, tt := range tests {
+		if got := rc.MatchTag(tt.tag); got != tt.matched {
+			t.Errorf("tag %s: expected %v, got %v", tt.tag, tt.matched, got)
+		}
+	}
+
+	onlyNegated := &ecrm.RepositoryConfig{Name: "app", Expires: "30d", KeepTagPatterns: []string{"!dev-*"}}
+	if err := onlyNegated.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !onlyNegated.MatchTag("v1") || onlyNegated.MatchTag("dev-1") {
+		t.Error("unexpected match by negated patterns only")
+	}
+}
+
+func TestInvalidPattern(t *testing.T) {
+	rc := &ecrm.RepositoryConfig{NamePattern: "re:^(prod", Expires: "30d"}
+	if err := rc.Validate(); err == nil {
+		t.Error("expected error for invalid regexp")
+	}
+	rc = &ecrm.RepositoryConfig{Name: "app", Expires: "30d", KeepTagPatterns: []string{"re:["}}
+	if err := rc.Validate(); err == nil {
+		t.Error("expected error for invalid regexp in keep_tag_patterns")
+	}
+	cc := &ecrm.ClusterConfig{NamePattern: "re:("}
+	if err := cc.Validate(); err == nil {
+		t.Error("expected error for invalid regexp in clusters")
+	}
+}
@@ -0,0 +1,213 @@
+package ecrm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+const PlanFileVersion = 1
+
+// PlanFile represents a saved plan that can be applied later by `ecrm apply`.
+type PlanFile struct {
+	Version      int                `json:"version"`
+	CreatedAt    time.Time          `json:"created_at"`
+	ConfigHash   string             `json:"config_hash"`
+	Scan         bool               `json:"scan"`
+	ScannedFiles []string           `json:"scanned_files,omitempty"`
+	Repository   RepositoryName     `json:"repository,omitempty"`
+	Summary      SummaryTable       `json:"summary"`
+	Registries   []*PlannedRegistry `json:"registries"`
+}
+
+// PlannedRegistry represents images to be deleted in a registry.
+type PlannedRegistry struct {
+	Name         string                           `json:"name,omitempty"`
+	Account      string                           `json:"account,omitempty"`
+	Region       string                           `json:"region"`
+	Images       map[RepositoryName][]string      `json:"images"`
+	Dependencies map[RepositoryName]DeletionGraph `json:"dependencies,omitempty"`
+	Quarantine   map[RepositoryName][]string      `json:"quarantine,omitempty"`
+	Restore      map[RepositoryName][]string      `json:"restore,omitempty"`
+	Archive      map[RepositoryName][]string      `json:"archive,omitempty"`
+}
+
+func NewPlanFile(c *Config, opt *Option, results PlanResults) *PlanFile {
+	regs := make([]*PlannedRegistry, 0, len(results))
+	for _, r := range results {
+		regs = append(regs, &PlannedRegistry{
+			Name:         r.Registry,
+			Account:      r.Account,
+			Region:       r.Region,
+			Images:       r.Deletable.digests(),
+			Dependencies: r.Dependencies,
+			Quarantine:   r.Quarantine.digests(),
+			Restore:      r.Restore.digests(),
+			Archive:      r.Archive.digests(),
+		})
+	}
+	return &PlanFile{
+		Version:      PlanFileVersion,
+		CreatedAt:    time.Now(),
+		ConfigHash:   c.Hash(),
+		Scan:         opt.Scan,
+		ScannedFiles: opt.ScannedFiles,
+		Repository:   opt.Repository,
+		Summary:      results.Merge().Summary,
+		Registries:   regs,
+	}
+}
+
+// Registry returns the planned registry by the name.
+func (pf *PlanFile) Registry(name string) *PlannedRegistry {
+	for _, r := range pf.Registries {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// Results returns the summaries of the plan for each planned registry.
+func (pf *PlanFile) Results() PlanResults {
+	results := make(PlanResults, 0, len(pf.Registries))
+	for _, pr := range pf.Registries {
+		r := &PlanResult{Registry: pr.Name, Account: pr.Account, Region: pr.Region}
+		for _, s := range pf.Summary {
+			if s.Account == pr.Account && s.Region == pr.Region {
+				r.Summary = append(r.Summary, s)
+			}
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// DeletableImageIDs returns the image identifiers to be deleted in the registry by the plan.
+func (pr *PlannedRegistry) DeletableImageIDs() DeletableImageIDs {
+	return imageIDsOf(pr.Images)
+}
+
+// result returns the plan result of the registry to be executed.
+func (pr *PlannedRegistry) result() *PlanResult {
+	return &PlanResult{
+		Registry:     pr.Name,
+		Account:      pr.Account,
+		Region:       pr.Region,
+		Deletable:    pr.DeletableImageIDs(),
+		Dependencies: pr.Dependencies,
+		Quarantine:   imageIDsOf(pr.Quarantine),
+		Restore:      imageIDsOf(pr.Restore),
+		Archive:      imageIDsOf(pr.Archive),
+	}
+}
+
+func imageIDsOf(images map[RepositoryName][]string) DeletableImageIDs {
+	ids := make(DeletableImageIDs, len(images))
+	for name, digests := range images {
+		for _, digest := range digests {
+			ids[name] = append(ids[name], ecrTypes.ImageIdentifier{ImageDigest: aws.String(digest)})
+		}
+	}
+	return ids
+}
+
+func (pf *PlanFile) Save(path string) error {
+	log.Println("[info] saving plan to", path)
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create plan file: %w", err)
+	}
+	defer f.Close()
+	return pf.Write(f)
+}
+
+func (pf *PlanFile) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(pf); err != nil {
+		return fmt.Errorf("failed to encode plan: %w", err)
+	}
+	return nil
+}
+
+func LoadPlanFile(path string) (*PlanFile, error) {
+	log.Println("[info] loading plan file:", path)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open plan file: %w", err)
+	}
+	pf := &PlanFile{}
+	if err := json.Unmarshal(b, pf); err != nil {
+		return nil, fmt.Errorf("failed to decode plan file: %w", err)
+	}
+	if pf.Version != PlanFileVersion {
+		return nil, fmt.Errorf("unsupported plan file version: %d", pf.Version)
+	}
+	return pf, nil
+}
+
+// VerifyConfig checks the plan was created by the same configuration and the planned registries are in the same regions.
+// regions is a map of registry names to their regions.
+func (pf *PlanFile) VerifyConfig(c *Config, regions map[string]string) error {
+	if pf.ConfigHash != c.Hash() {
+		return fmt.Errorf("config has been changed since the plan was created at %s", pf.CreatedAt.Format(time.RFC3339))
+	}
+	for _, pr := range pf.Registries {
+		region, ok := regions[pr.Name]
+		if !ok {
+			return fmt.Errorf("registry %s in the plan is not defined in the config", pr.Name)
+		}
+		if pr.Region != region {
+			return fmt.Errorf("region %s does not match the plan region %s", region, pr.Region)
+		}
+	}
+	return nil
+}
+
+// VerifyImages checks the planned images still exist, are not in use and are still planned as in result.
+// current is a map of image details in the repositories now, and result is the plan result of the registry planned now.
+func (pr *PlannedRegistry) VerifyImages(current map[RepositoryName]map[string]ecrTypes.ImageDetail, result *PlanResult, keepImages Images) error {
+	stale := pr.verifyPlannedImages("deleted", pr.Images, current, result.Deletable, keepImages)
+	stale += pr.verifyPlannedImages("quarantined", pr.Quarantine, current, result.Quarantine, keepImages)
+	stale += pr.verifyPlannedImages("archived", pr.Archive, current, result.Archive, keepImages)
+	// images to be restored are in use, so only their existence and the plan are checked.
+	stale += pr.verifyPlannedImages("restored", pr.Restore, current, result.Restore, nil)
+	if stale > 0 {
+		return fmt.Errorf("plan is stale: %d images cannot be processed as planned. run plan again", stale)
+	}
+	return nil
+}
+
+// verifyPlannedImages returns the number of the planned images that cannot be processed by action now.
+func (pr *PlannedRegistry) verifyPlannedImages(action string, planned map[RepositoryName][]string, current map[RepositoryName]map[string]ecrTypes.ImageDetail, ids DeletableImageIDs, keepImages Images) int {
+	var stale int
+	for name, digests := range planned {
+		plannedDigests := make([]string, 0, len(ids[name]))
+		for _, id := range ids[name] {
+			plannedDigests = append(plannedDigests, aws.ToString(id.ImageDigest))
+		}
+		for _, digest := range digests {
+			d, found := current[name][digest]
+			switch {
+			case !found:
+				log.Printf("[warn] %s@%s no longer exists", name, digest)
+			case isImageInUse(d, pr.Region, keepImages):
+				log.Printf("[warn] %s@%s is now in use", name, digest)
+			case !slices.Contains(plannedDigests, digest):
+				log.Printf("[warn] %s@%s is no longer to be %s", name, digest, action)
+			default:
+				continue
+			}
+			stale++
+		}
+	}
+	return stale
+}
@@ -0,0 +1,163 @@
+package ecrm_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/fujiwara/ecrm"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPlanFile(t *testing.T) {
+	region := "ap-northeast-1"
+	c, err := ecrm.LoadConfig("ecrm.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := ecrm.DeletableImageIDs{
+		"my-service": {
+			{ImageDigest: aws.String("sha256:aaa")},
+			{ImageDigest: aws.String("sha256:bbb")},
+		},
+	}
+	opt := &ecrm.Option{Scan: true, ScannedFiles: []string{"testdata/images.json"}}
+	path := filepath.Join(t.TempDir(), "plan.json")
+	results := ecrm.PlanResults{{Region: region, Account: "012345678901", Deletable: ids}}
+	if err := ecrm.NewPlanFile(c, opt, results).Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	pf, err := ecrm.LoadPlanFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr := pf.Registry("")
+	if pr == nil {
+		t.Fatal("planned registry not found")
+	}
+	if pr.Account != "012345678901" || pr.Region != region {
+		t.Errorf("unexpected registry: %s/%s", pr.Account, pr.Region)
+	}
+	if diff := cmp.Diff(ids, pr.DeletableImageIDs(), cmp.Comparer(func(a, b ecrTypes.ImageIdentifier) bool {
+		return aws.ToString(a.ImageDigest) == aws.ToString(b.ImageDigest)
+	})); diff != "" {
+		t.Errorf("unexpected deletable image ids: %s", diff)
+	}
+	if err := pf.VerifyConfig(c, map[string]string{"": region}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := pf.VerifyConfig(c, map[string]string{"": "us-east-1"}); err == nil {
+		t.Error("should be errored by region mismatch")
+	}
+	if err := pf.VerifyConfig(c, map[string]string{"dr": region}); err == nil {
+		t.Error("should be errored by undefined registry")
+	}
+
+	detail := func(digest string, tags ...string) ecrTypes.ImageDetail {
+		return ecrTypes.ImageDetail{
+			RegistryId:     aws.String("012345678901"),
+			RepositoryName: aws.String("my-service"),
+			ImageDigest:    aws.String(digest),
+			ImageTags:      tags,
+		}
+	}
+	current := map[ecrm.RepositoryName]map[string]ecrTypes.ImageDetail{
+		"my-service": {
+			"sha256:aaa": detail("sha256:aaa"),
+			"sha256:bbb": detail("sha256:bbb", "v1"),
+		},
+	}
+	if err := pr.VerifyImages(current, &ecrm.PlanResult{Deletable: ids}, make(ecrm.Images)); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	inUse := make(ecrm.Images)
+	inUse.Add("012345678901.dkr.ecr.ap-northeast-1.amazonaws.com/my-service:v1", "taskdef")
+	if err := pr.VerifyImages(current, &ecrm.PlanResult{Deletable: ids}, inUse); err == nil {
+		t.Error("should be errored by newly referenced image")
+	}
+
+	delete(current["my-service"], "sha256:aaa")
+	if err := pr.VerifyImages(current, &ecrm.PlanResult{Deletable: ids}, make(ecrm.Images)); err == nil {
+		t.Error("should be errored by missing image")
+	}
+}
+
+func TestVerifyPlannedImages(t *testing.T) {
+	detail := func(digest string, tags ...string) ecrTypes.ImageDetail {
+		return ecrTypes.ImageDetail{
+			RegistryId:     aws.String("012345678901"),
+			RepositoryName: aws.String("my-service"),
+			ImageDigest:    aws.String(digest),
+			ImageTags:      tags,
+		}
+	}
+	pr := &ecrm.PlannedRegistry{
+		Account:    "012345678901",
+		Region:     "ap-northeast-1",
+		Quarantine: map[ecrm.RepositoryName][]string{"my-service": {"sha256:aaa"}},
+		Restore:    map[ecrm.RepositoryName][]string{"my-service": {"sha256:bbb"}},
+		Archive:    map[ecrm.RepositoryName][]string{"my-service": {"sha256:ccc"}},
+	}
+	result := &ecrm.PlanResult{
+		Quarantine: ecrm.DeletableImageIDs{"my-service": {{ImageDigest: aws.String("sha256:aaa")}}},
+		Restore:    ecrm.DeletableImageIDs{"my-service": {{ImageDigest: aws.String("sha256:bbb")}}},
+		Archive:    ecrm.DeletableImageIDs{"my-service": {{ImageDigest: aws.String("sha256:ccc")}}},
+	}
+	current := map[ecrm.RepositoryName]map[string]ecrTypes.ImageDetail{
+		"my-service": {
+			"sha256:aaa": detail("sha256:aaa", "v1"),
+			"sha256:bbb": detail("sha256:bbb", "v2"),
+			"sha256:ccc": detail("sha256:ccc", "v3"),
+		},
+	}
+	// the image to be restored is in use
+	inUse := make(ecrm.Images)
+	inUse.Add("012345678901.dkr.ecr.ap-northeast-1.amazonaws.com/my-service:v2", "taskdef")
+	if err := pr.VerifyImages(current, result, inUse); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	inUse.Add("012345678901.dkr.ecr.ap-northeast-1.amazonaws.com/my-service:v1", "taskdef")
+	if err := pr.VerifyImages(current, result, inUse); err == nil {
+		t.Error("should be errored by the image to be quarantined in use")
+	}
+
+	if err := pr.VerifyImages(current, &ecrm.PlanResult{Restore: result.Restore, Archive: result.Archive}, make(ecrm.Images)); err == nil {
+		t.Error("should be errored by the image no longer to be quarantined")
+	}
+
+	inUse = make(ecrm.Images)
+	inUse.Add("012345678901.dkr.ecr.ap-northeast-1.amazonaws.com/my-service:v3", "taskdef")
+	if err := pr.VerifyImages(current, result, inUse); err == nil {
+		t.Error("should be errored by the image to be archived in use")
+	}
+
+	if err := pr.VerifyImages(current, &ecrm.PlanResult{Quarantine: result.Quarantine, Restore: result.Restore}, make(ecrm.Images)); err == nil {
+		t.Error("should be errored by the image no longer to be archived")
+	}
+
+	delete(current["my-service"], "sha256:bbb")
+	if err := pr.VerifyImages(current, result, make(ecrm.Images)); err == nil {
+		t.Error("should be errored by the missing image to be restored")
+	}
+}
+
+func TestApplyOptionValidate(t *testing.T) {
+	tests := []struct {
+		opt   *ecrm.Option
+		valid bool
+	}{
+		{&ecrm.Option{Apply: true, Delete: true, PlanFile: "plan.json"}, true},
+		{&ecrm.Option{Apply: true, Delete: true}, false},
+		{&ecrm.Option{Apply: true, Delete: true, PlanFile: "plan.json", Repository: "foo"}, false},
+		{&ecrm.Option{Apply: true, Delete: true, PlanFile: "plan.json", ScannedFiles: []string{"images.json"}}, false},
+	}
+	for i, tt := range tests {
+		if err := tt.opt.Validate(); (err == nil) != tt.valid {
+			t.Errorf("test[%d]: unexpected validation result: %v", i, err)
+		}
+	}
+}
@@ -3,8 +3,10 @@ package ecrm
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"slices"
 	"sort"
 	"strings"
@@ -13,6 +15,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ecr"
 	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/dustin/go-humanize"
 	oci "github.com/google/go-containerregistry/pkg/v1"
 	ociTypes "github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/samber/lo"
@@ -38,13 +41,101 @@ func (d DeletableImageIDs) RepositoryNames() []RepositoryName {
 	return names
 }
 
-// Plan scans repositories and find expired images, and returns a summary table and a map of deletable image identifiers.
+// digests returns the image digests for each repository.
+func (d DeletableImageIDs) digests() map[RepositoryName][]string {
+	images := make(map[RepositoryName][]string, len(d))
+	for _, name := range d.RepositoryNames() {
+		digests := make([]string, 0, len(d[name]))
+		for _, id := range d[name] {
+			digests = append(digests, aws.ToString(id.ImageDigest))
+		}
+		images[name] = digests
+	}
+	return images
+}
+
+// PlanResult is a result of Planner.Plan for a registry.
+type PlanResult struct {
+	Registry  string
+	Account   string
+	Region    string
+	Summary   SummaryTable
+	Deletable DeletableImageIDs
+	Decisions ImageDecisions
+
+	// Dependencies are the deletion graphs of the deletable images for each repository.
+	Dependencies map[RepositoryName]DeletionGraph
+
+	// Quarantine and Restore are images to be tagged and untagged in the soft delete mode.
+	Quarantine DeletableImageIDs
+	Restore    DeletableImageIDs
+
+	// Archive are images to be archived by action archive.
+	Archive DeletableImageIDs
+}
+
+// PlanResults is a list of PlanResult for each registry.
+type PlanResults []*PlanResult
+
+// Merge merges summaries and decisions of all registries.
+func (rs PlanResults) Merge() *PlanResult {
+	merged := &PlanResult{Summary: SummaryTable{}}
+	for _, r := range rs {
+		merged.Summary = append(merged.Summary, r.Summary...)
+		merged.Decisions = append(merged.Decisions, r.Decisions...)
+	}
+	merged.Summary.Sort()
+	return merged
+}
+
+// Plan scans repositories and find expired images, and returns a summary table, a map of deletable image identifiers
+// and decisions for each image.
 //
 // keepImages is a set of images in use by ECS tasks / task definitions / lambda functions
 // so that they are not deleted
-func (p *Planner) Plan(ctx context.Context, rcs []*RepositoryConfig, keepImages Images, repo RepositoryName) (SummaryTable, DeletableImageIDs, error) {
-	idsMaps := make(DeletableImageIDs)
-	sums := SummaryTable{}
+func (p *Planner) Plan(ctx context.Context, rcs []*RepositoryConfig, keepImages Images, repo RepositoryName) (*PlanResult, error) {
+	result := &PlanResult{
+		Region:       p.region,
+		Summary:      SummaryTable{},
+		Deletable:    make(DeletableImageIDs),
+		Dependencies: make(map[RepositoryName]DeletionGraph),
+		Archive:      make(DeletableImageIDs),
+	}
+	repos, err := p.repositories(ctx, rcs, repo)
+	if err != nil {
+		return nil, err
+	}
+	for _, repo := range repos {
+		name := RepositoryName(*repo.RepositoryName)
+		rc := matchRepositoryConfig(rcs, name)
+		rp, err := p.unusedImageIdentifiers(ctx, name, rc, keepImages)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find unused image identifiers: %w", err)
+		}
+		result.Account = aws.ToString(repo.RegistryId)
+		for _, s := range rp.sums {
+			s.Account, s.Region = result.Account, result.Region
+			s.archive = rc.Action == ActionArchive
+		}
+		for _, dc := range rp.decisions {
+			dc.Account, dc.Region = result.Account, result.Region
+		}
+		result.Summary = append(result.Summary, rp.sums...)
+		result.Deletable[name] = rp.expired
+		result.Dependencies[name] = rp.graph
+		if len(rp.archive) > 0 {
+			result.Archive[name] = rp.archive
+		}
+		result.Decisions = append(result.Decisions, rp.decisions...)
+	}
+	result.Summary.Sort()
+	return result, nil
+}
+
+// repositories returns the repositories matched by the repositories rules.
+// If repo is specified, returns the repository only (or nothing if not found).
+func (p *Planner) repositories(ctx context.Context, rcs []*RepositoryConfig, repo RepositoryName) ([]ecrTypes.Repository, error) {
+	var matched []ecrTypes.Repository
 	in := &ecr.DescribeRepositoriesInput{}
 	if repo != "" {
 		in.RepositoryNames = []string{string(repo)}
@@ -53,142 +144,327 @@ func (p *Planner) Plan(ctx context.Context, rcs []*RepositoryConfig, keepImages
 	for pager.HasMorePages() {
 		repos, err := pager.NextPage(ctx)
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to describe repositories: %w", err)
-		}
-	REPO:
-		for _, repo := range repos.Repositories {
-			name := RepositoryName(*repo.RepositoryName)
-			var rc *RepositoryConfig
-			for _, _rc := range rcs {
-				if _rc.MatchName(name) {
-					rc = _rc
-					break
-				}
-			}
-			if rc == nil {
-				continue REPO
+			var notFound *ecrTypes.RepositoryNotFoundException
+			if repo != "" && errors.As(err, &notFound) {
+				log.Printf("[warn] repository %s is not found in %s", repo, p.region)
+				return nil, nil
 			}
-			imageIDs, sum, err := p.unusedImageIdentifiers(ctx, name, rc, keepImages)
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to find unused image identifiers: %w", err)
+			return nil, fmt.Errorf("failed to describe repositories: %w", err)
+		}
+		for _, r := range repos.Repositories {
+			if matchRepositoryConfig(rcs, RepositoryName(aws.ToString(r.RepositoryName))) != nil {
+				matched = append(matched, r)
 			}
-			sums = append(sums, sum...)
-			idsMaps[name] = imageIDs
 		}
 	}
-	sums.Sort()
-	return sums, idsMaps, nil
+	return matched, nil
+}
+
+// repoPlan is a plan for a repository.
+type repoPlan struct {
+	// expired are image identifiers to be deleted, ordered parent-first (image indexes, soci indexes, then images).
+	expired []ecrTypes.ImageIdentifier
+	// graph is the deletion graph of the expired images built from the manifests of image indexes.
+	graph DeletionGraph
+	// archive are image identifiers to be archived by action archive.
+	archive   []ecrTypes.ImageIdentifier
+	sums      RepoSummary
+	decisions ImageDecisions
 }
 
 // unusedImageIdentifiers finds image identifiers(by image digests) from the repository.
-func (p *Planner) unusedImageIdentifiers(ctx context.Context, repo RepositoryName, rc *RepositoryConfig, keepImages Images) ([]ecrTypes.ImageIdentifier, RepoSummary, error) {
+func (p *Planner) unusedImageIdentifiers(ctx context.Context, repo RepositoryName, rc *RepositoryConfig, keepImages Images) (*repoPlan, error) {
 	sums := NewRepoSummary(repo)
 	images, imageIndexes, sociIndexes, idByTags, err := p.listImageDetails(ctx, repo)
 	if err != nil {
-		return nil, sums, err
+		return nil, err
 	}
+	quarantined := stripSystemTags(images)
+	maps.Copy(quarantined, stripSystemTags(imageIndexes))
+	maps.Copy(quarantined, stripSystemTags(sociIndexes))
 	log.Printf("[info] %s has %d images, %d image indexes, %d soci indexes", repo, len(images), len(imageIndexes), len(sociIndexes))
 
-	// Pre-compute which image indexes should be kept, then register their constituent
-	// platform-specific images (e.g. linux/amd64, linux/arm64) into keepImages.
+	// Pre-compute which image indexes should be kept, then find their constituent
+	// platform-specific images (e.g. linux/amd64, linux/arm64).
 	// This must happen before evaluating individual images so that constituents of
 	// a kept image index are not incorrectly marked as expired.
-	keptIndexDigests, keptIndexIDs := p.computeKeptImageIndexIDs(rc, keepImages, imageIndexes)
-	if err := p.addConstituentImagesToKeep(ctx, repo, keptIndexIDs, keepImages); err != nil {
-		return nil, sums, fmt.Errorf("failed to protect constituent images of kept image indexes: %w", err)
+	semvers := rc.KeepSemver.keptDigests(slices.Concat(images, imageIndexes))
+	indexDecisions, keptIndexIDs := p.computeKeptImageIndexIDs(repo, rc, keepImages, semvers, imageIndexes)
+	children, err := indexManifests(ctx, p.ecr, repo, imageIndexes, keptIndexIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifests of image indexes: %w", err)
 	}
+	constituents := constituentImages(repo, keptIndexIDs, children)
 
-	expiredIds := make([]ecrTypes.ImageIdentifier, 0)
-	expiredImageIndexes := newSet()
-	var keepCount int64
-IMAGE:
-	for _, d := range images {
-		tag, tagged := imageTag(d)
-		displayName := string(repo) + ":" + tag
-		sums.Add(d)
-
-		// Check if the image is in use (digest)
-		imageURISha256 := ImageURI(fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com/%s@%s", *d.RegistryId, p.region, *d.RepositoryName, *d.ImageDigest))
-		log.Printf("[debug] checking %s", imageURISha256)
-		if keepImages.Contains(imageURISha256) {
-			log.Printf("[info] %s@%s is in used, keep it", repo, *d.ImageDigest)
-			continue IMAGE
+	var expiredImages, expiredIndexes, expiredSocis, archiveIds []ecrTypes.ImageIdentifier
+	archive := rc.Action == ActionArchive
+	// expire expires the image, or archives it by action archive
+	expire := func(d ecrTypes.ImageDetail, dc *ImageDecision, expired *[]ecrTypes.ImageIdentifier) {
+		id := ecrTypes.ImageIdentifier{ImageDigest: d.ImageDigest}
+		if archive {
+			dc.Archive = true
+			sums.Archive(d)
+			archiveIds = append(archiveIds, id)
+			return
 		}
-
-		// Check if the image is in use or conditions (tag)
-		for _, tag := range d.ImageTags {
-			if rc.MatchTag(tag) {
-				log.Printf("[info] image %s:%s is matched by tag condition, keep it", repo, tag)
-				continue IMAGE
-			}
-			imageURI := ImageURI(fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com/%s:%s", *d.RegistryId, p.region, *d.RepositoryName, tag))
-			log.Printf("[debug] checking %s", imageURI)
-			if keepImages.Contains(imageURI) {
-				log.Printf("[info] image %s:%s is in used, keep it", repo, tag)
-				continue IMAGE
+		sums.Expire(d)
+		*expired = append(*expired, id)
+	}
+	graph := make(DeletionGraph)
+	expiredImageIndexes := newSet()
+	// expireImage expires the image or the image index, and collects the tags to find their soci indexes
+	expireImage := func(d ecrTypes.ImageDetail, dc *ImageDecision) {
+		if !isImageIndex(d) {
+			expire(d, dc, &expiredImages)
+			tagSha256 := strings.Replace(*d.ImageDigest, "sha256:", "sha256-", 1)
+			if _, found := idByTags[tagSha256]; found {
+				expiredImageIndexes.add(tagSha256)
 			}
+			return
 		}
-
-		// Check if the image is expired
-		pushedAt := *d.ImagePushedAt
-		if !rc.IsExpired(pushedAt) {
-			log.Printf("[info] image %s is not expired, keep it", displayName)
-			continue IMAGE
+		expire(d, dc, &expiredIndexes)
+		for _, tag := range d.ImageTags {
+			expiredImageIndexes.add(tag)
 		}
-
-		if tagged {
-			keepCount++
-			if keepCount <= rc.KeepCount {
-				log.Printf("[info] image %s is in keep_count %d <= %d, keep it", displayName, keepCount, rc.KeepCount)
-				continue IMAGE
-			}
+		for _, child := range children[aws.ToString(d.ImageDigest)] {
+			graph.add(child, aws.ToString(d.ImageDigest))
 		}
-
-		// Don't match any conditions, so expired
-		log.Printf("[notice] image %s is expired %s %s", displayName, *d.ImageDigest, pushedAt.Format(time.RFC3339))
-		expiredIds = append(expiredIds, ecrTypes.ImageIdentifier{ImageDigest: d.ImageDigest})
-		sums.Expire(d)
-
-		tagSha256 := strings.Replace(*d.ImageDigest, "sha256:", "sha256-", 1)
-		if _, found := idByTags[tagSha256]; found {
-			expiredImageIndexes.add(tagSha256)
+	}
+	decisions := make(ImageDecisions, 0, len(images)+len(imageIndexes)+len(sociIndexes))
+	keepCounts := make(map[string]int64)
+	for _, d := range images {
+		sums.Add(d)
+		dc := p.decideImage(repo, rc, keepImages, constituents, semvers, keepCounts, d)
+		decisions = append(decisions, dc)
+		if !dc.Expired {
+			continue
 		}
+		expireImage(d, dc)
 	}
 
-	for _, d := range imageIndexes {
+	for i, d := range imageIndexes {
 		log.Printf("[debug] is an image index %s", *d.ImageDigest)
 		sums.Add(d)
-		if keptIndexDigests.contains(*d.ImageDigest) {
+		dc := indexDecisions[i]
+		decisions = append(decisions, dc)
+		if !dc.Expired {
 			continue
 		}
 		log.Printf("[notice] image index %s@%s is expired %s", repo, *d.ImageDigest, d.ImagePushedAt.Format(time.RFC3339))
-		sums.Expire(d)
-		expiredIds = append(expiredIds, ecrTypes.ImageIdentifier{ImageDigest: d.ImageDigest})
-		for _, tag := range d.ImageTags {
-			expiredImageIndexes.add(tag)
+		expireImage(d, dc)
+	}
+
+	if rc.MaxTotalSize != "" {
+		details := slices.Concat(images, imageIndexes)
+		budgeted, kept := sizeBudget(rc.maxTotalSize, details, decisions, children)
+		for _, b := range budgeted {
+			d, dc := details[b.index], decisions[b.index]
+			log.Printf("[notice] %s@%s is expired by max_total_size %s", repo, *d.ImageDigest, rc.MaxTotalSize)
+			if b.parent != "" {
+				dc.expire(ReasonMaxTotalSize, "constituent of image index %s expired by max_total_size %s", b.parent, rc.MaxTotalSize)
+			} else {
+				dc.expire(ReasonMaxTotalSize, "oldest image over max_total_size %s", rc.MaxTotalSize)
+			}
+			expireImage(d, dc)
+		}
+		if kept > rc.maxTotalSize {
+			log.Printf("[warn] %s kept size %s exceeds max_total_size %s, because the other images are protected", repo, humanize.Bytes(uint64(kept)), rc.MaxTotalSize)
+			sums.OverBudget(kept - rc.maxTotalSize)
 		}
 	}
 
 	sociIds, err := p.findSociIndex(ctx, repo, expiredImageIndexes.members())
 	if err != nil {
-		return nil, sums, fmt.Errorf("failed to find soci index: %w", err)
+		return nil, fmt.Errorf("failed to find soci index: %w", err)
 	}
 
-SOCI_INDEX:
 	for _, d := range sociIndexes {
 		log.Printf("[debug] is soci index %s", *d.ImageDigest)
 		sums.Add(d)
-		for _, id := range sociIds {
-			if aws.ToString(id.ImageDigest) == aws.ToString(d.ImageDigest) {
-				log.Printf("[notice] %s@%s is expired (soci index)", repo, *d.ImageDigest)
+		dc := newImageDecision(repo, SummaryTypeSociIndex, d)
+		decisions = append(decisions, dc)
+		parent, found := sociIds[aws.ToString(d.ImageDigest)]
+		if !found {
+			dc.keep(ReasonNoExpiredImageIndex, "not referenced by expired image indexes")
+			continue
+		}
+		log.Printf("[notice] %s@%s is expired (soci index)", repo, *d.ImageDigest)
+		dc.expire(ReasonSociIndexOfExpiredIndex, "soci index of expired image index %s", strings.Replace(parent, "sha256-", "sha256:", 1))
+		expire(d, dc, &expiredSocis)
+		if id, found := idByTags[parent]; found {
+			graph.add(aws.ToString(d.ImageDigest), aws.ToString(id.ImageDigest))
+		}
+	}
+
+	if archive {
+		archived, err := p.listArchivedImages(ctx, repo)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[info] %s has %d archived images", repo, len(archived))
+		for _, d := range archived {
+			sums.Add(d)
+			dc := p.decideArchivedImage(repo, rc, keepImages, d)
+			decisions = append(decisions, dc)
+			if dc.Expired {
 				sums.Expire(d)
-				expiredIds = append(expiredIds, ecrTypes.ImageIdentifier{ImageDigest: d.ImageDigest})
-				continue SOCI_INDEX
+				expiredImages = append(expiredImages, ecrTypes.ImageIdentifier{ImageDigest: d.ImageDigest})
 			}
 		}
 	}
 
-	return expiredIds, sums, nil
+	for _, dc := range decisions {
+		dc.Rule = rc.String()
+		dc.QuarantinedAt = quarantined[dc.Digest]
+	}
+	return &repoPlan{
+		expired:   slices.Concat(expiredIndexes, expiredSocis, expiredImages),
+		graph:     graph,
+		archive:   archiveIds,
+		sums:      sums,
+		decisions: decisions,
+	}, nil
+}
+
+// decideArchivedImage decides whether the archived image is kept or deleted by delete_after.
+func (p *Planner) decideArchivedImage(repo RepositoryName, rc *RepositoryConfig, keepImages Images, d ecrTypes.ImageDetail) *ImageDecision {
+	typ := SummaryTypeImage
+	if isImageIndex(d) {
+		typ = SummaryTypeImageIndex
+	} else if isSociIndex(d) {
+		typ = SummaryTypeSociIndex
+	}
+	dc := newImageDecision(repo, typ, d)
+	dc.Status = string(d.ImageStatus)
+	if usedBy := imageUsedBy(d, p.region, keepImages); len(usedBy) > 0 {
+		dc.UsedBy = usedBy
+		return dc.keep(ReasonInUse, "in use by %s (archived)", strings.Join(usedBy, ", "))
+	}
+	dc.step("archived image is not in use")
+	archivedAt := aws.ToTime(d.LastArchivedAt)
+	if rc.DeleteAfter == "" {
+		return dc.keep(ReasonArchived, "archived at %s and delete_after is not defined", archivedAt.Format(time.RFC3339))
+	}
+	if !rc.IsArchiveExpired(archivedAt) {
+		return dc.keep(ReasonArchived, "archived within delete_after %s", rc.DeleteAfter)
+	}
+	dc.step("archived at %s is before delete_after %s", archivedAt.Format(time.RFC3339), rc.DeleteAfter)
+	return dc.expire(ReasonArchiveExpired, "archived before delete_after %s", rc.DeleteAfter)
+}
+
+// listArchivedImages returns the archived images in the repository.
+func (p *Planner) listArchivedImages(ctx context.Context, repo RepositoryName) ([]ecrTypes.ImageDetail, error) {
+	var archived []ecrTypes.ImageDetail
+	pager := ecr.NewDescribeImagesPaginator(p.ecr, &ecr.DescribeImagesInput{
+		RepositoryName: aws.String(string(repo)),
+		Filter:         &ecrTypes.DescribeImagesFilter{ImageStatus: ecrTypes.ImageStatusFilterArchived},
+	})
+	for pager.HasMorePages() {
+		imgs, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe archived images: %w", err)
+		}
+		archived = append(archived, imgs.ImageDetails...)
+	}
+	stripSystemTags(archived)
+	return archived, nil
+}
+
+// decideImage decides whether the container image is kept or expired.
+// Images are evaluated in order of pushed time (newest first) because keep_count is counted up in this method.
+// keepCounts counts images for keep_count by streams ("" for images not in any stream).
+// semvers is a map of image digests kept by keep_semver to the reasons.
+func (p *Planner) decideImage(repo RepositoryName, rc *RepositoryConfig, keepImages Images, constituents, semvers map[string]string, keepCounts map[string]int64, d ecrTypes.ImageDetail) *ImageDecision {
+	dc := newImageDecision(repo, SummaryTypeImage, d)
+	tag, _ := imageTag(d)
+	displayName := string(repo) + ":" + tag
+
+	// Check if the image is in use (digest)
+	imageURISha256 := ImageURI(fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com/%s@%s", *d.RegistryId, p.region, *d.RepositoryName, *d.ImageDigest))
+	log.Printf("[debug] checking %s", imageURISha256)
+	if keepImages.Contains(imageURISha256) {
+		log.Printf("[info] %s@%s is in used, keep it", repo, *d.ImageDigest)
+		dc.UsedBy = keepImages[imageURISha256].sortedMembers()
+		return dc.keep(ReasonInUse, "in use by %s", strings.Join(dc.UsedBy, ", "))
+	}
+	dc.step("%s is not in use", imageURISha256.Short())
+	if parent, found := constituents[*d.ImageDigest]; found {
+		log.Printf("[info] %s@%s is a constituent of kept image index %s, keep it", repo, *d.ImageDigest, parent)
+		return dc.keep(ReasonImageIndexConstituent, "constituent of kept image index %s", parent)
+	}
+	dc.step("not a constituent of kept image indexes")
+
+	// Check if the image is in use or conditions (tag)
+	for _, tag := range d.ImageTags {
+		if pattern, matched := rc.matchedTagPattern(tag); matched {
+			log.Printf("[info] image %s:%s is matched by tag condition, keep it", repo, tag)
+			return dc.keep(ReasonKeepTagPattern, "tag %s matches keep_tag_patterns %s", tag, pattern)
+		}
+		dc.step("tag %s does not match keep_tag_patterns %v", tag, rc.KeepTagPatterns)
+		imageURI := ImageURI(fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com/%s:%s", *d.RegistryId, p.region, *d.RepositoryName, tag))
+		log.Printf("[debug] checking %s", imageURI)
+		if keepImages.Contains(imageURI) {
+			log.Printf("[info] image %s:%s is in used, keep it", repo, tag)
+			dc.UsedBy = keepImages[imageURI].sortedMembers()
+			return dc.keep(ReasonInUse, "in use by %s", strings.Join(dc.UsedBy, ", "))
+		}
+		dc.step("%s is not in use", imageURI.Short())
+	}
+	if decideByExpireTagPatterns(rc, dc, d) {
+		log.Printf("[info] image %s is matched by expire_tag_patterns, %s", displayName, dc.action())
+		return dc
+	}
+
+	// Check if the image is expired
+	pushedAt := *d.ImagePushedAt
+	rt := rc.retentionFor(d.ImageTags)
+	if !rt.isExpired(pushedAt) {
+		log.Printf("[info] image %s is not expired, keep it", displayName)
+		return dc.keep(ReasonNotExpired, "pushed within expires %s%s", rt.expires, rt.of())
+	}
+	dc.step("pushed at %s is before expires %s%s", pushedAt.Format(time.RFC3339), rt.expires, rt.of())
+	if keepRecentlyPulled(rc, dc, d) {
+		log.Printf("[info] image %s is pulled within %s, keep it", displayName, rc.PulledWithin)
+		return dc
+	}
+	if keepSemver(rc, dc, semvers) {
+		log.Printf("[info] image %s is kept by keep_semver", displayName)
+		return dc
+	}
+
+	if rt.counted {
+		keepCounts[rt.stream]++
+		if n := keepCounts[rt.stream]; n <= rt.keepCount {
+			log.Printf("[info] image %s is in keep_count %d <= %d%s, keep it", displayName, n, rt.keepCount, rt.of())
+			return dc.keep(ReasonKeepCount, "within keep_count %d <= %d%s", n, rt.keepCount, rt.of())
+		}
+		dc.step("out of keep_count %d > %d%s", keepCounts[rt.stream], rt.keepCount, rt.of())
+	} else {
+		dc.step("untagged images are not counted in keep_count")
+	}
+
+	// Don't match any conditions, so expired
+	log.Printf("[notice] image %s is expired %s %s", displayName, *d.ImageDigest, pushedAt.Format(time.RFC3339))
+	return dc.expire(ReasonExpired, "pushed before expires %s%s and not matched any keep conditions", rt.expires, rt.of())
+}
+
+// currentImages returns a map of image details in the repository keyed by image digest.
+func (p *Planner) currentImages(ctx context.Context, repo RepositoryName) (map[string]ecrTypes.ImageDetail, error) {
+	images := make(map[string]ecrTypes.ImageDetail)
+	pager := ecr.NewDescribeImagesPaginator(p.ecr, &ecr.DescribeImagesInput{
+		RepositoryName: aws.String(string(repo)),
+		Filter:         &ecrTypes.DescribeImagesFilter{ImageStatus: ecrTypes.ImageStatusFilterAny},
+	})
+	for pager.HasMorePages() {
+		imgs, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe images: %w", err)
+		}
+		for _, img := range imgs.ImageDetails {
+			images[aws.ToString(img.ImageDigest)] = img
+		}
+	}
+	return images, nil
 }
 
 func (p *Planner) listImageDetails(ctx context.Context, repo RepositoryName) ([]ecrTypes.ImageDetail, []ecrTypes.ImageDetail, []ecrTypes.ImageDetail, map[string]ecrTypes.ImageIdentifier, error) {
@@ -231,49 +507,145 @@ func (p *Planner) listImageDetails(ctx context.Context, repo RepositoryName) ([]
 
 // computeKeptImageIndexIDs determines which image indexes should be kept by applying
 // all standard retention criteria (in-use references, tag patterns, expiry, keep_count).
-// Returns the set of kept digests (for quick lookup) and their identifiers (for BatchGetImage).
-func (p *Planner) computeKeptImageIndexIDs(rc *RepositoryConfig, keepImages Images, imageIndexes []ecrTypes.ImageDetail) (set, []ecrTypes.ImageIdentifier) {
-	keptDigests := newSet()
+// Returns the decisions for each image index (in the same order as imageIndexes) and identifiers of kept indexes (for BatchGetImage).
+func (p *Planner) computeKeptImageIndexIDs(repo RepositoryName, rc *RepositoryConfig, keepImages Images, semvers map[string]string, imageIndexes []ecrTypes.ImageDetail) (ImageDecisions, []ecrTypes.ImageIdentifier) {
+	decisions := make(ImageDecisions, 0, len(imageIndexes))
 	keptIDs := make([]ecrTypes.ImageIdentifier, 0)
-	var keepCount int64
+	keepCounts := make(map[string]int64)
 
 	for _, d := range imageIndexes {
-		keep := false
-		if isKeptImageIndex(d, p.region, keepImages) {
-			keep = true
-		} else {
-			tagMatched := slices.ContainsFunc(d.ImageTags, rc.MatchTag)
-			if tagMatched {
-				keep = true
-			} else if !rc.IsExpired(*d.ImagePushedAt) {
-				keep = true
-			} else {
-				_, tagged := imageTag(d)
-				if tagged {
-					keepCount++
-					if keepCount <= rc.KeepCount {
-						keep = true
-					}
-				}
-			}
-		}
-		if keep {
-			keptDigests.add(*d.ImageDigest)
+		dc := p.decideImageIndex(repo, rc, keepImages, semvers, keepCounts, d)
+		decisions = append(decisions, dc)
+		if !dc.Expired {
 			keptIDs = append(keptIDs, ecrTypes.ImageIdentifier{ImageDigest: d.ImageDigest})
 		}
 	}
-	return keptDigests, keptIDs
+	return decisions, keptIDs
 }
 
-// addConstituentImagesToKeep fetches manifests of kept image indexes and adds every
-// constituent platform-specific image digest to keepImages so they are not deleted.
-func (p *Planner) addConstituentImagesToKeep(ctx context.Context, repo RepositoryName, keptIndexIDs []ecrTypes.ImageIdentifier, keepImages Images) error {
-	if len(keptIndexIDs) == 0 {
-		return nil
+// decideImageIndex decides whether the image index is kept or expired.
+// Image indexes are evaluated in order of pushed time (newest first) because keep_count is counted up in this method.
+func (p *Planner) decideImageIndex(repo RepositoryName, rc *RepositoryConfig, keepImages Images, semvers map[string]string, keepCounts map[string]int64, d ecrTypes.ImageDetail) *ImageDecision {
+	dc := newImageDecision(repo, SummaryTypeImageIndex, d)
+	if usedBy := imageUsedBy(d, p.region, keepImages); len(usedBy) > 0 {
+		dc.UsedBy = usedBy
+		return dc.keep(ReasonInUse, "in use by %s", strings.Join(usedBy, ", "))
 	}
+	dc.step("image index is not in use")
 
-	for _, c := range lo.Chunk(keptIndexIDs, batchGetImageLimit) {
-		res, err := p.ecr.BatchGetImage(ctx, &ecr.BatchGetImageInput{
+	if tag, pattern, matched := rc.matchedTags(d.ImageTags); matched {
+		return dc.keep(ReasonKeepTagPattern, "tag %s matches keep_tag_patterns %s", tag, pattern)
+	}
+	dc.step("tags %v do not match keep_tag_patterns %v", d.ImageTags, rc.KeepTagPatterns)
+	if decideByExpireTagPatterns(rc, dc, d) {
+		return dc
+	}
+
+	rt := rc.retentionFor(d.ImageTags)
+	if !rt.isExpired(*d.ImagePushedAt) {
+		return dc.keep(ReasonNotExpired, "pushed within expires %s%s", rt.expires, rt.of())
+	}
+	dc.step("pushed at %s is before expires %s%s", d.ImagePushedAt.Format(time.RFC3339), rt.expires, rt.of())
+	if keepRecentlyPulled(rc, dc, d) {
+		return dc
+	}
+	if keepSemver(rc, dc, semvers) {
+		return dc
+	}
+
+	if rt.counted {
+		keepCounts[rt.stream]++
+		if n := keepCounts[rt.stream]; n <= rt.keepCount {
+			return dc.keep(ReasonKeepCount, "within keep_count %d <= %d%s", n, rt.keepCount, rt.of())
+		}
+		dc.step("out of keep_count %d > %d%s", keepCounts[rt.stream], rt.keepCount, rt.of())
+	} else {
+		dc.step("untagged image indexes are not counted in keep_count")
+	}
+	return dc.expire(ReasonExpired, "pushed before expires %s%s and not matched any keep conditions", rt.expires, rt.of())
+}
+
+// decideByExpireTagPatterns decides the image whose tags all match expire_tag_patterns, and reports true if decided.
+// The image is expired by expires of the pattern and never counted in keep_count.
+func decideByExpireTagPatterns(rc *RepositoryConfig, dc *ImageDecision, d ecrTypes.ImageDetail) bool {
+	et, matched := rc.matchedExpireTagPattern(d.ImageTags)
+	if !matched {
+		if len(rc.ExpireTagPatterns) > 0 {
+			dc.step("tags %v do not match expire_tag_patterns", d.ImageTags)
+		}
+		return false
+	}
+	pushedAt := aws.ToTime(d.ImagePushedAt)
+	if !et.IsExpired(pushedAt) {
+		dc.keep(ReasonNotExpired, "pushed within expires %s of expire_tag_patterns %s", et.Expires, et.Pattern)
+		return true
+	}
+	dc.step("pushed at %s is before expires %s of expire_tag_patterns %s", pushedAt.Format(time.RFC3339), et.Expires, et.Pattern)
+	if keepRecentlyPulled(rc, dc, d) {
+		return true
+	}
+	dc.expire(ReasonExpireTagPattern, "tags match expire_tag_patterns %s and pushed before expires %s", et.Pattern, et.Expires)
+	return true
+}
+
+// keepRecentlyPulled keeps the image and reports true if it was pulled within pulled_within.
+func keepRecentlyPulled(rc *RepositoryConfig, dc *ImageDecision, d ecrTypes.ImageDetail) bool {
+	if rc.PulledWithin == "" {
+		return false
+	}
+	if d.LastRecordedPullTime == nil {
+		dc.step("never pulled")
+		return false
+	}
+	pulledAt := *d.LastRecordedPullTime
+	if rc.IsRecentlyPulled(pulledAt) {
+		dc.keep(ReasonRecentlyPulled, "pulled at %s within pulled_within %s", pulledAt.Format(time.RFC3339), rc.PulledWithin)
+		return true
+	}
+	dc.step("pulled at %s is before pulled_within %s", pulledAt.Format(time.RFC3339), rc.PulledWithin)
+	return false
+}
+
+// keepSemver keeps the image and reports true if it is kept by keep_semver.
+func keepSemver(rc *RepositoryConfig, dc *ImageDecision, semvers map[string]string) bool {
+	if rc.KeepSemver == nil {
+		return false
+	}
+	if reason, found := semvers[dc.Digest]; found {
+		dc.keep(ReasonKeepSemver, "%s", reason)
+		return true
+	}
+	dc.step("not kept by keep_semver")
+	return false
+}
+
+// imageGetter is an interface of ECR client to get image manifests.
+type imageGetter interface {
+	BatchGetImage(ctx context.Context, params *ecr.BatchGetImageInput, optFns ...func(*ecr.Options)) (*ecr.BatchGetImageOutput, error)
+}
+
+// indexManifests fetches manifests of the image indexes and returns a map of
+// every image index digest to its child manifest digests (except soci indexes).
+// Failing to get a manifest of a kept image index is an error, because its constituent images
+// could not be protected. Manifests of the other image indexes are fetched best-effort,
+// they only order the deletion.
+func indexManifests(ctx context.Context, client imageGetter, repo RepositoryName, imageIndexes []ecrTypes.ImageDetail, keptIndexIDs []ecrTypes.ImageIdentifier) (map[string][]string, error) {
+	children := make(map[string][]string, len(imageIndexes))
+	if len(imageIndexes) == 0 {
+		return children, nil
+	}
+	ids := make([]ecrTypes.ImageIdentifier, 0, len(imageIndexes))
+	for _, d := range imageIndexes {
+		ids = append(ids, ecrTypes.ImageIdentifier{ImageDigest: d.ImageDigest})
+	}
+	kept := newSet()
+	for _, id := range keptIndexIDs {
+		kept.add(aws.ToString(id.ImageDigest))
+	}
+
+	var keptFailures int
+	for _, c := range lo.Chunk(ids, batchGetImageLimit) {
+		res, err := client.BatchGetImage(ctx, &ecr.BatchGetImageInput{
 			ImageIds:       c,
 			RepositoryName: aws.String(string(repo)),
 			AcceptedMediaTypes: []string{
@@ -282,13 +654,16 @@ func (p *Planner) addConstituentImagesToKeep(ctx context.Context, repo Repositor
 			},
 		})
 		if err != nil {
-			return fmt.Errorf("failed to batch get image index manifest: %w", err)
+			return nil, fmt.Errorf("failed to batch get image index manifest: %w", err)
 		}
-		if len(res.Failures) > 0 {
-			for _, f := range res.Failures {
-				log.Printf("[warn] failed to get image index manifest: %s %s", aws.ToString(f.ImageId.ImageDigest), f.FailureCode)
+		for _, f := range res.Failures {
+			digest := aws.ToString(f.ImageId.ImageDigest)
+			if kept.contains(digest) {
+				log.Printf("[warn] failed to get image index manifest: %s@%s %s", repo, digest, f.FailureCode)
+				keptFailures++
+				continue
 			}
-			return fmt.Errorf("failed to get %d image index manifest(s), aborting to avoid deleting constituent images", len(res.Failures))
+			log.Printf("[info] failed to get image index manifest: %s@%s %s, skipping the deletion order of its constituent images", repo, digest, f.FailureCode)
 		}
 		for _, img := range res.Images {
 			if img.ImageManifest == nil {
@@ -299,41 +674,66 @@ func (p *Planner) addConstituentImagesToKeep(ctx context.Context, repo Repositor
 				log.Printf("[warn] failed to parse image index manifest for %s: %s", aws.ToString(img.ImageId.ImageDigest), err)
 				continue
 			}
+			parent := aws.ToString(img.ImageId.ImageDigest)
 			for _, d := range m.Manifests {
 				if d.ArtifactType == MediaTypeSociIndex {
 					continue
 				}
-				constituentURI := ImageURI(fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com/%s@%s",
-					aws.ToString(img.RegistryId), p.region, aws.ToString(img.RepositoryName), d.Digest.String()))
-				if keepImages.Add(constituentURI, "image_index_constituent") {
-					log.Printf("[info] constituent image %s is added to keep list by parent image index", constituentURI)
-				}
+				children[parent] = append(children[parent], d.Digest.String())
+			}
+		}
+	}
+	if keptFailures > 0 {
+		return nil, fmt.Errorf("failed to get %d manifest(s) of kept image indexes, aborting to avoid deleting constituent images", keptFailures)
+	}
+	return children, nil
+}
+
+// constituentImages returns a map of every constituent platform-specific image digest
+// of the kept image indexes to its parent image index digest.
+func constituentImages(repo RepositoryName, keptIndexIDs []ecrTypes.ImageIdentifier, children map[string][]string) map[string]string {
+	constituents := make(map[string]string)
+	for _, id := range keptIndexIDs {
+		parent := aws.ToString(id.ImageDigest)
+		for _, child := range children[parent] {
+			if _, found := constituents[child]; !found {
+				constituents[child] = parent
+				log.Printf("[info] constituent image %s@%s is kept by parent image index", repo, child)
 			}
 		}
 	}
-	return nil
+	return constituents
 }
 
 // isKeptImageIndex reports whether an image index is directly referenced in keepImages
 // (by digest or by tag), meaning it is in use by ECS tasks / task definitions / lambda functions.
 func isKeptImageIndex(d ecrTypes.ImageDetail, region string, keepImages Images) bool {
+	return isImageInUse(d, region, keepImages)
+}
+
+// isImageInUse reports whether an image is referenced in keepImages by digest or by tag.
+func isImageInUse(d ecrTypes.ImageDetail, region string, keepImages Images) bool {
+	return len(imageUsedBy(d, region, keepImages)) > 0
+}
+
+// imageUsedBy returns the sources that use the image referenced by digest or by tag.
+func imageUsedBy(d ecrTypes.ImageDetail, region string, keepImages Images) []string {
+	usedBy := make(consumers)
 	imageURISha256 := ImageURI(fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com/%s@%s",
 		aws.ToString(d.RegistryId), region, aws.ToString(d.RepositoryName), aws.ToString(d.ImageDigest)))
-	if keepImages.Contains(imageURISha256) {
-		return true
-	}
+	usedBy = usedBy.union(keepImages[imageURISha256])
 	for _, tag := range d.ImageTags {
 		imageURI := ImageURI(fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com/%s:%s",
 			aws.ToString(d.RegistryId), region, aws.ToString(d.RepositoryName), tag))
-		if keepImages.Contains(imageURI) {
-			return true
-		}
+		usedBy = usedBy.union(keepImages[imageURI])
 	}
-	return false
+	return usedBy.sortedMembers()
 }
 
-func (p *Planner) findSociIndex(ctx context.Context, repo RepositoryName, imageTags []string) ([]ecrTypes.ImageIdentifier, error) {
-	ids := make([]ecrTypes.ImageIdentifier, 0, len(imageTags))
+// findSociIndex finds soci indexes referenced by the image indexes (by tags).
+// Returns a map of soci index digest to the tag of the referencing image index.
+func (p *Planner) findSociIndex(ctx context.Context, repo RepositoryName, imageTags []string) (map[string]string, error) {
+	ids := make(map[string]string, len(imageTags))
 
 	for _, c := range lo.Chunk(imageTags, batchGetImageLimit) {
 		imageIds := make([]ecrTypes.ImageIdentifier, 0, len(c))
@@ -363,7 +763,7 @@ func (p *Planner) findSociIndex(ctx context.Context, repo RepositoryName, imageT
 			}
 			for _, d := range m.Manifests {
 				if d.ArtifactType == MediaTypeSociIndex {
-					ids = append(ids, ecrTypes.ImageIdentifier{ImageDigest: aws.String(d.Digest.String())})
+					ids[d.Digest.String()] = aws.ToString(img.ImageId.ImageTag)
 				}
 			}
 		}
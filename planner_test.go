@@ -1,9 +1,12 @@
 package ecrm_test
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
 	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
 	"github.com/fujiwara/ecrm"
 )
@@ -84,3 +87,290 @@ func TestIsKeptImageIndex(t *testing.T) {
 		})
 	}
 }
+
+// testImageDetail returns an image detail of my-service pushed age ago.
+func testImageDetail(digest string, age time.Duration, tags ...string) ecrTypes.ImageDetail {
+	return ecrTypes.ImageDetail{
+		RegistryId:     aws.String("012345678901"),
+		RepositoryName: aws.String("my-service"),
+		ImageDigest:    aws.String(digest),
+		ImageTags:      tags,
+		ImagePushedAt:  aws.Time(time.Now().Add(-age)),
+	}
+}
+
+type decisionTest struct {
+	detail  ecrTypes.ImageDetail
+	expired bool
+	reason  ecrm.DecisionReason
+}
+
+// assertDecisions decides the images of my-service in order, and returns the decisions.
+func assertDecisions(t *testing.T, rc *ecrm.RepositoryConfig, keepImages ecrm.Images, constituents map[string]string, tests []decisionTest) []*ecrm.ImageDecision {
+	t.Helper()
+	p := ecrm.NewTestPlanner("ap-northeast-1")
+	keepCounts := map[string]int64{}
+	decisions := make([]*ecrm.ImageDecision, 0, len(tests))
+	for _, tt := range tests {
+		dc := p.DecideImage("my-service", rc, keepImages, constituents, nil, keepCounts, tt.detail)
+		if dc.Expired != tt.expired || dc.Reason != tt.reason {
+			t.Errorf("%s: unexpected decision expired=%v reason=%s (%s)", *tt.detail.ImageDigest, dc.Expired, dc.Reason, dc.Message)
+		}
+		decisions = append(decisions, dc)
+	}
+	return decisions
+}
+
+func TestDecideImage(t *testing.T) {
+	rc := &ecrm.RepositoryConfig{
+		Name:            "my-service",
+		Expires:         "30d",
+		KeepCount:       1,
+		KeepTagPatterns: []string{"release-*"},
+	}
+	if err := rc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	keepImages := make(ecrm.Images)
+	keepImages.Add("012345678901.dkr.ecr.ap-northeast-1.amazonaws.com/my-service@sha256:aaa", "arn:aws:ecs:ap-northeast-1:012345678901:task-definition/app:1")
+	keepImages.Add("012345678901.dkr.ecr.ap-northeast-1.amazonaws.com/my-service:v2", "arn:aws:lambda:ap-northeast-1:012345678901:function:app:3")
+	constituents := map[string]string{"sha256:bbb": "sha256:index"}
+
+	old := 60 * 24 * time.Hour
+	assertDecisions(t, rc, keepImages, constituents, []decisionTest{
+		{testImageDetail("sha256:aaa", old), false, ecrm.ReasonInUse},
+		{testImageDetail("sha256:bbb", old), false, ecrm.ReasonImageIndexConstituent},
+		{testImageDetail("sha256:ccc", old, "release-1"), false, ecrm.ReasonKeepTagPattern},
+		{testImageDetail("sha256:ddd", old, "v2"), false, ecrm.ReasonInUse},
+		{testImageDetail("sha256:eee", 0), false, ecrm.ReasonNotExpired},
+		{testImageDetail("sha256:fff", old, "v1"), false, ecrm.ReasonKeepCount},
+		{testImageDetail("sha256:ggg", old, "v0"), true, ecrm.ReasonExpired},
+		{testImageDetail("sha256:hhh", old), true, ecrm.ReasonExpired},
+	})
+}
+
+func TestDecideImagePulledWithin(t *testing.T) {
+	now := time.Now()
+	rc := &ecrm.RepositoryConfig{
+		Name:         "my-service",
+		Expires:      "30d",
+		PulledWithin: "7d",
+	}
+	if err := rc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	detail := func(digest string, pulledAt *time.Time) ecrTypes.ImageDetail {
+		d := testImageDetail(digest, 200*24*time.Hour)
+		d.LastRecordedPullTime = pulledAt
+		return d
+	}
+	tests := []decisionTest{
+		{detail("sha256:aaa", aws.Time(now.Add(-24*time.Hour))), false, ecrm.ReasonRecentlyPulled},
+		{detail("sha256:bbb", aws.Time(now.Add(-30*24*time.Hour))), true, ecrm.ReasonExpired},
+		{detail("sha256:ccc", nil), true, ecrm.ReasonExpired},
+	}
+	decisions := assertDecisions(t, rc, ecrm.Images{}, nil, tests)
+	for i, tt := range tests {
+		if pulledAt := aws.ToTime(tt.detail.LastRecordedPullTime); !decisions[i].PulledAt.Equal(pulledAt) {
+			t.Errorf("%s: unexpected pulled_at %s", *tt.detail.ImageDigest, decisions[i].PulledAt)
+		}
+	}
+}
+
+func TestDecideImageStreams(t *testing.T) {
+	keepCount := int64(1)
+	rc := &ecrm.RepositoryConfig{
+		Name:      "my-service",
+		Expires:   "30d",
+		KeepCount: 2,
+		Streams: &ecrm.StreamsConfig{
+			Pattern:   `^(?P<stream>.+)-[0-9a-f]{7,40}$`,
+			Expires:   "7d",
+			KeepCount: &keepCount,
+		},
+	}
+	if err := rc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	day := 24 * time.Hour
+	// newest first
+	assertDecisions(t, rc, ecrm.Images{}, nil, []decisionTest{
+		{testImageDetail("sha256:aaa", 1*day, "main-1234567"), false, ecrm.ReasonNotExpired},
+		{testImageDetail("sha256:bbb", 10*day, "main-89abcde"), false, ecrm.ReasonKeepCount},
+		{testImageDetail("sha256:ccc", 11*day, "main-fedcba9"), true, ecrm.ReasonExpired},
+		{testImageDetail("sha256:ddd", 12*day, "feature-foo-0123456"), false, ecrm.ReasonKeepCount},
+		{testImageDetail("sha256:eee", 13*day, "pr-123-abcdef0"), false, ecrm.ReasonKeepCount},
+		{testImageDetail("sha256:fff", 14*day, "v1"), false, ecrm.ReasonNotExpired},
+		{testImageDetail("sha256:ggg", 40*day, "v0"), false, ecrm.ReasonKeepCount},
+		{testImageDetail("sha256:hhh", 41*day, "feature-foo-7654321"), true, ecrm.ReasonExpired},
+	})
+}
+
+func TestStreamsConfigValidate(t *testing.T) {
+	for _, c := range []ecrm.StreamsConfig{
+		{},
+		{Pattern: `^main-[0-9a-f]+$`},
+		{Pattern: `^(.+`},
+		{Pattern: `^(.+)-[0-9a-f]+$`, Expires: "foo"},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("expected error for %#v", c)
+		}
+	}
+}
+
+func TestDecideImageExpireTagPatterns(t *testing.T) {
+	rc := &ecrm.RepositoryConfig{
+		Name:            "my-service",
+		Expires:         "30d",
+		KeepCount:       1,
+		KeepTagPatterns: []string{"latest"},
+		ExpireTagPatterns: []*ecrm.ExpireTagPattern{
+			{Pattern: "pr-*", Expires: "3d"},
+			{Pattern: "tmp-*", Expires: "1d"},
+		},
+	}
+	if err := rc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	keepImages := make(ecrm.Images)
+	keepImages.Add("012345678901.dkr.ecr.ap-northeast-1.amazonaws.com/my-service:pr-3", "arn:aws:lambda:ap-northeast-1:012345678901:function:app:3")
+	day := 24 * time.Hour
+	// newest first
+	assertDecisions(t, rc, keepImages, nil, []decisionTest{
+		{testImageDetail("sha256:aaa", 2*day, "pr-1"), false, ecrm.ReasonNotExpired},
+		{testImageDetail("sha256:bbb", 2*day, "tmp-1"), true, ecrm.ReasonExpireTagPattern},
+		{testImageDetail("sha256:ccc", 2*day, "pr-2", "tmp-2"), false, ecrm.ReasonNotExpired},
+		{testImageDetail("sha256:ddd", 4*day, "pr-3"), false, ecrm.ReasonInUse},
+		{testImageDetail("sha256:eee", 4*day, "pr-4", "latest"), false, ecrm.ReasonKeepTagPattern},
+		{testImageDetail("sha256:fff", 40*day, "pr-5"), true, ecrm.ReasonExpireTagPattern},
+		// pr-* images are not counted in keep_count
+		{testImageDetail("sha256:ggg", 41*day, "v2"), false, ecrm.ReasonKeepCount},
+		{testImageDetail("sha256:hhh", 42*day, "v1", "pr-6"), true, ecrm.ReasonExpired},
+	})
+}
+
+func TestDecideImageUntagged(t *testing.T) {
+	rc := &ecrm.RepositoryConfig{
+		Name:      "my-service",
+		Expires:   "30d",
+		KeepCount: 1,
+		Untagged:  &ecrm.UntaggedConfig{Expires: "1d", KeepCount: 1},
+	}
+	if err := rc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	day := 24 * time.Hour
+	// newest first
+	assertDecisions(t, rc, ecrm.Images{}, nil, []decisionTest{
+		{testImageDetail("sha256:aaa", 12*time.Hour), false, ecrm.ReasonNotExpired},
+		{testImageDetail("sha256:bbb", 2*day, "v3"), false, ecrm.ReasonNotExpired},
+		{testImageDetail("sha256:ccc", 3*day), false, ecrm.ReasonKeepCount},
+		{testImageDetail("sha256:ddd", 4*day), true, ecrm.ReasonExpired},
+		{testImageDetail("sha256:eee", 40*day, "v2"), false, ecrm.ReasonKeepCount},
+		{testImageDetail("sha256:fff", 41*day, "v1"), true, ecrm.ReasonExpired},
+	})
+}
+
+func TestDecideArchivedImage(t *testing.T) {
+	now := time.Now()
+	rc := &ecrm.RepositoryConfig{
+		Name:        "my-service",
+		Expires:     "30d",
+		Action:      ecrm.ActionArchive,
+		DeleteAfter: "90d",
+	}
+	if err := rc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	keepImages := make(ecrm.Images)
+	keepImages.Add("012345678901.dkr.ecr.ap-northeast-1.amazonaws.com/my-service:v1", "arn:aws:ecs:ap-northeast-1:012345678901:task-definition/app:1")
+
+	detail := func(digest string, archivedAt time.Time, tags ...string) ecrTypes.ImageDetail {
+		return ecrTypes.ImageDetail{
+			RegistryId:     aws.String("012345678901"),
+			RepositoryName: aws.String("my-service"),
+			ImageDigest:    aws.String(digest),
+			ImageTags:      tags,
+			ImagePushedAt:  aws.Time(archivedAt.Add(-30 * 24 * time.Hour)),
+			ImageStatus:    ecrTypes.ImageStatusArchived,
+			LastArchivedAt: aws.Time(archivedAt),
+		}
+	}
+	old := now.Add(-100 * 24 * time.Hour)
+	tests := []struct {
+		detail  ecrTypes.ImageDetail
+		expired bool
+		reason  ecrm.DecisionReason
+	}{
+		{detail("sha256:aaa", old, "v1"), false, ecrm.ReasonInUse},
+		{detail("sha256:bbb", now), false, ecrm.ReasonArchived},
+		{detail("sha256:ccc", old), true, ecrm.ReasonArchiveExpired},
+	}
+	p := ecrm.NewTestPlanner("ap-northeast-1")
+	for _, tt := range tests {
+		dc := p.DecideArchivedImage("my-service", rc, keepImages, tt.detail)
+		if dc.Expired != tt.expired || dc.Reason != tt.reason {
+			t.Errorf("%s: unexpected decision expired=%v reason=%s (%s)", *tt.detail.ImageDigest, dc.Expired, dc.Reason, dc.Message)
+		}
+		if dc.Status != "ARCHIVED" {
+			t.Errorf("%s: unexpected status %s", *tt.detail.ImageDigest, dc.Status)
+		}
+	}
+
+	invalid := &ecrm.RepositoryConfig{Name: "my-service", Expires: "30d", DeleteAfter: "90d"}
+	if err := invalid.Validate(); err == nil {
+		t.Error("delete_after without action archive should be invalid")
+	}
+}
+
+type fakeImageGetter struct {
+	manifests map[string]string
+}
+
+func (f *fakeImageGetter) BatchGetImage(ctx context.Context, in *ecr.BatchGetImageInput, _ ...func(*ecr.Options)) (*ecr.BatchGetImageOutput, error) {
+	out := &ecr.BatchGetImageOutput{}
+	for _, id := range in.ImageIds {
+		m, found := f.manifests[aws.ToString(id.ImageDigest)]
+		if !found {
+			out.Failures = append(out.Failures, ecrTypes.ImageFailure{
+				ImageId:     &id,
+				FailureCode: ecrTypes.ImageFailureCodeImageNotFound,
+			})
+			continue
+		}
+		out.Images = append(out.Images, ecrTypes.Image{ImageId: &id, ImageManifest: aws.String(m)})
+	}
+	return out, nil
+}
+
+func TestIndexManifests(t *testing.T) {
+	const amd64 = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	client := &fakeImageGetter{
+		manifests: map[string]string{
+			"sha256:kept": `{"schemaVersion":2,"manifests":[{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"` + amd64 + `","size":1}]}`,
+		},
+	}
+	indexes := []ecrTypes.ImageDetail{
+		{ImageDigest: aws.String("sha256:kept")},
+		{ImageDigest: aws.String("sha256:expired")},
+	}
+
+	// a manifest of an expired image index is fetched best-effort
+	children, err := ecrm.IndexManifests(context.Background(), client, "foo", indexes, []ecrTypes.ImageIdentifier{{ImageDigest: aws.String("sha256:kept")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := children["sha256:kept"]; len(got) != 1 || got[0] != amd64 {
+		t.Errorf("unexpected children of kept image index: %v", got)
+	}
+	if got, found := children["sha256:expired"]; found {
+		t.Errorf("unexpected children of expired image index: %v", got)
+	}
+
+	// a manifest of a kept image index is required
+	_, err = ecrm.IndexManifests(context.Background(), client, "foo", indexes, []ecrTypes.ImageIdentifier{{ImageDigest: aws.String("sha256:expired")}})
+	if err == nil {
+		t.Error("expected an error for the missing manifest of the kept image index")
+	}
+}
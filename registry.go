@@ -0,0 +1,64 @@
+package ecrm
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// registry is an ECR registry (an account and region pair) managed by ecrm.
+type registry struct {
+	name    string
+	region  string
+	planner *Planner
+}
+
+func (r *registry) String() string {
+	if r.name == "" {
+		return r.region
+	}
+	return r.name
+}
+
+// registries returns the registries defined in the config.
+// If no registries are defined, the registry of the default credentials is returned.
+func (app *App) registries(ctx context.Context, c *Config) ([]*registry, error) {
+	if len(c.Registries) == 0 {
+		return []*registry{{region: app.region, planner: NewPlanner(app.awsCfg)}}, nil
+	}
+	regs := make([]*registry, 0, len(c.Registries))
+	for _, t := range c.Registries {
+		cfg, err := t.AWSConfig(ctx, app.awsCfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure registry %s: %w", t, err)
+		}
+		regs = append(regs, &registry{
+			name:    t.Name,
+			region:  cfg.Region,
+			planner: NewPlanner(cfg),
+		})
+	}
+	return regs, nil
+}
+
+// plan plans deletion in the registry with the repositories rules for it.
+func (r *registry) plan(ctx context.Context, c *Config, keepImages Images, repo RepositoryName) (*PlanResult, error) {
+	result, err := r.planner.Plan(ctx, c.repositoriesFor(r.name), keepImages, repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to plan on registry %s: %w", r, err)
+	}
+	result.Registry = r.name
+	if c.SoftDelete != nil {
+		result.applySoftDelete(c.SoftDelete, time.Now())
+	}
+	return result, nil
+}
+
+// regions returns a map of registry names to their regions.
+func regions(regs []*registry) map[string]string {
+	m := make(map[string]string, len(regs))
+	for _, r := range regs {
+		m[r.name] = r.region
+	}
+	return m
+}
@@ -2,35 +2,142 @@ package ecrm
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/apprunner"
+	"github.com/aws/aws-sdk-go-v2/service/batch"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
 	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/scheduler"
 	"github.com/samber/lo"
 )
 
 type Scanner struct {
 	Images Images
 
-	ecs    *ecs.Client
-	lambda *lambda.Client
+	awsCfg      aws.Config
+	ecs         *ecs.Client
+	lambda      *lambda.Client
+	apprunner   appRunnerClient
+	batch       *batch.Client
+	eventbridge eventBridgeClient
+	scheduler   schedulerClient
 }
 
 func NewScanner(cfg aws.Config) *Scanner {
 	return &Scanner{
-		Images: make(Images),
-		ecs:    ecs.NewFromConfig(cfg),
-		lambda: lambda.NewFromConfig(cfg),
+		Images:      make(Images),
+		awsCfg:      cfg,
+		ecs:         ecs.NewFromConfig(cfg),
+		lambda:      lambda.NewFromConfig(cfg),
+		apprunner:   apprunner.NewFromConfig(cfg),
+		batch:       batch.NewFromConfig(cfg),
+		eventbridge: eventbridge.NewFromConfig(cfg),
+		scheduler:   scheduler.NewFromConfig(cfg),
 	}
 }
 
 func (s *Scanner) Scan(ctx context.Context, c *Config) error {
 	log.Println("[info] scanning resources")
 
+	// the clients are shared, and the images are collected separately to check the scan result
+	scanned := *s
+	scanned.Images = make(Images)
+	if len(c.Targets) == 0 {
+		if err := scanned.scanAWSResources(ctx, c); err != nil {
+			return err
+		}
+		if err := checkScannedImages(c, scanned.Images); err != nil {
+			return err
+		}
+	} else if err := scanned.scanTargets(ctx, c); err != nil {
+		return err
+	}
+	s.Images.Merge(scanned.Images)
+
+	if err := s.scanKubernetes(ctx, c.Kubernetes); err != nil {
+		return err
+	}
+
+	if err := s.scanExternalCommands(ctx, c.ExternalCommands); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkScannedImages is a sanity check of the scan result.
+// A broken scan (wrong region, missing permissions, etc.) makes all images look unused, so it fails
+// when no images in use are found although clusters or lambda_functions are configured.
+func checkScannedImages(c *Config, imgs Images) error {
+	if len(imgs) > 0 {
+		return nil
+	}
+	if len(c.Clusters) > 0 || len(c.LambdaFunctions) > 0 {
+		return errors.New("no images in use are found although clusters or lambda_functions are configured. check the region and permissions of the scan")
+	}
+	return nil
+}
+
+// scanTargets scans AWS resources in the targets concurrently, and merges the results.
+func (s *Scanner) scanTargets(ctx context.Context, c *Config) error {
+	var wg sync.WaitGroup
+	scanners := make([]*Scanner, len(c.Targets))
+	errs := make([]error, len(c.Targets))
+	for i, t := range c.Targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cfg, err := t.AWSConfig(ctx, s.awsCfg)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			log.Printf("[info] scanning resources in target %s", t)
+			scanners[i] = NewScanner(cfg)
+			if err := scanners[i].scanAWSResources(ctx, c); err != nil {
+				errs[i] = fmt.Errorf("failed to scan target %s: %w", t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	scanned := make([]Images, len(scanners))
+	for i, ts := range scanners {
+		scanned[i] = ts.Images
+	}
+	return mergeTargetImages(c, s.Images, scanned)
+}
+
+// mergeTargetImages checks the images scanned in each target and merges them into imgs.
+// Each target is checked separately, because a broken scan of a target is hidden by the images of the other targets.
+func mergeTargetImages(c *Config, imgs Images, scanned []Images) error {
+	var errs []error
+	for i, ts := range scanned {
+		log.Printf("[info] %d image URIs in use in target %s", len(ts), c.Targets[i])
+		if err := checkScannedImages(c, ts); err != nil {
+			errs = append(errs, fmt.Errorf("target %s: %w", c.Targets[i], err))
+			continue
+		}
+		imgs.Merge(ts)
+	}
+	return errors.Join(errs...)
+}
+
+// scanAWSResources scans ECS and Lambda resources with the clients of the scanner.
+func (s *Scanner) scanAWSResources(ctx context.Context, c *Config) error {
 	// collect images in use by ECS tasks / task definitions
 	var taskdefs []taskdef
 	if tds, err := s.scanClusters(ctx, c.Clusters); err != nil {
@@ -38,6 +145,13 @@ func (s *Scanner) Scan(ctx context.Context, c *Config) error {
 	} else {
 		taskdefs = append(taskdefs, tds...)
 	}
+	if c.SkipScheduledTasks {
+		log.Println("[warn] scheduled tasks are not scanned by skip_scheduled_tasks. the images used only by scheduled tasks are not kept")
+	} else if tds, err := s.scanScheduledTasks(ctx, c.Clusters); err != nil {
+		return err
+	} else {
+		taskdefs = append(taskdefs, tds...)
+	}
 	if tds, err := s.collectTaskdefs(ctx, c.TaskDefinitions); err != nil {
 		return err
 	} else {
@@ -52,10 +166,18 @@ func (s *Scanner) Scan(ctx context.Context, c *Config) error {
 		return err
 	}
 
-	if err := s.scanExternalCommands(ctx, c.ExternalCommands); err != nil {
+	// collect images in use by Batch job definitions / jobs
+	if err := s.scanBatchJobDefinitions(ctx, c.BatchJobDefinitions); err != nil {
+		return err
+	}
+	if err := s.scanBatchJobQueues(ctx, c.BatchJobQueues); err != nil {
 		return err
 	}
 
+	// collect images in use by App Runner services
+	if err := s.scanAppRunnerServices(ctx, c.AppRunnerServices); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -72,10 +194,21 @@ func (s *Scanner) LoadFiles(files []string) error {
 	return nil
 }
 
-func (s *Scanner) Save(w io.Writer) error {
+// Save writes the scanned image URIs in the format of the version.
+// version 1 is a legacy JSON array of image URIs, and version 2 preserves consumers of each image URI.
+func (s *Scanner) Save(w io.Writer, version int) error {
 	log.Println("[info] saving scanned image URIs")
-	if err := s.Images.Print(w); err != nil {
-		return err
+	switch version {
+	case 1:
+		if err := s.Images.Print(w); err != nil {
+			return err
+		}
+	case ScannedFileVersion:
+		if err := s.Images.PrintV2(w, time.Now()); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported scanned file version: %d", version)
 	}
 	log.Println("[info] saved", len(s.Images), "image URIs")
 	return nil
@@ -83,21 +216,26 @@ func (s *Scanner) Save(w io.Writer) error {
 
 // collectImages collects images in use by ECS tasks / task definitions
 func (s *Scanner) collectImages(ctx context.Context, taskdefs []taskdef) error {
-	dup := newSet()
+	usedBy := make(map[string][]Consumer)
+	names := make([]string, 0, len(taskdefs))
 	for _, td := range taskdefs {
 		tds := td.String()
-		if dup.contains(tds) {
-			continue
+		if _, found := usedBy[tds]; !found {
+			names = append(names, tds)
 		}
-		dup.add(tds)
+		usedBy[tds] = append(usedBy[tds], td.usedBy)
+	}
 
+	for _, tds := range names {
 		ids, err := s.extractECRImages(ctx, tds)
 		if err != nil {
 			return err
 		}
 		for _, id := range ids {
-			if s.Images.Add(id, tds) {
-				log.Printf("[info] image %s is in use by taskdef %s", id.String(), tds)
+			for _, c := range usedBy[tds] {
+				if s.Images.AddConsumer(id, c) {
+					log.Printf("[info] image %s is in use by taskdef %s", id.String(), c.String())
+				}
 			}
 		}
 	}
@@ -190,16 +328,61 @@ func (s *Scanner) collectTaskdefs(ctx context.Context, tcs []*TaskdefConfig) ([]
 			if err != nil {
 				return tds, err
 			}
+			td.usedBy = newConsumer(ConsumerTypeECSTaskDefinition, tdArn)
 			tds = append(tds, td)
 		}
 	}
 	return tds, nil
 }
 
+// serviceTaskdefs returns task definitions of the deployments and the task sets of the service.
+// Services with CODE_DEPLOY or EXTERNAL deployment controllers have task sets,
+// including the standby side of a blue/green deployment to roll back.
+// Task sets are not filtered by status, because the status is always PRIMARY, ACTIVE or DRAINING
+// and all of them have running tasks.
+func serviceTaskdefs(sv ecsTypes.Service, clusterName string) ([]taskdef, error) {
+	var tds []taskdef
+	dup := newSet()
+	add := func(tdArn, by string) error {
+		td, err := parseTaskdefArn(tdArn)
+		if err != nil {
+			return err
+		}
+		if !dup.add(td.String()) {
+			return nil
+		}
+		td.usedBy = newConsumer(ConsumerTypeECSService, tdArn)
+		td.usedBy.Service = aws.ToString(sv.ServiceName)
+		td.usedBy.Cluster = clusterName
+		tds = append(tds, td)
+		log.Printf("[info] taskdef %s is used by %s on service %s/%s", td.String(), by, aws.ToString(sv.ServiceName), clusterName)
+		return nil
+	}
+	for _, dp := range sv.Deployments {
+		if err := add(aws.ToString(dp.TaskDefinition), aws.ToString(dp.Status)+" deployment"); err != nil {
+			return nil, err
+		}
+	}
+	for _, ts := range sv.TaskSets {
+		if err := add(aws.ToString(ts.TaskDefinition), fmt.Sprintf("%s task set %s", aws.ToString(ts.Status), aws.ToString(ts.Id))); err != nil {
+			return nil, err
+		}
+	}
+	return tds, nil
+}
+
 // availableResourcesInCluster scans task definitions and images in use in the cluster
 func (s *Scanner) availableResourcesInCluster(ctx context.Context, clusterArn string) ([]taskdef, error) {
 	clusterName := clusterArnToName(clusterArn)
-	tdArns := make(set)
+	var tds []taskdef
+	dup := newSet()
+	addTaskdef := func(td taskdef) bool {
+		if !dup.add(td.String() + " " + td.usedBy.String()) {
+			return false
+		}
+		tds = append(tds, td)
+		return true
+	}
 
 	log.Printf("[debug] Checking tasks in %s", clusterArn)
 	taskArns := make([]string, 0)
@@ -234,28 +417,34 @@ func (s *Scanner) availableResourcesInCluster(ctx context.Context, clusterArn st
 			if err != nil {
 				return nil, err
 			}
-			if tdArns.add(tdArn) {
+			c := newConsumer(ConsumerTypeECSTask, tdArn)
+			c.Cluster = clusterName
+			if sv, found := strings.CutPrefix(aws.ToString(task.Group), "service:"); found {
+				c.Service = sv
+			}
+			td.usedBy = c
+			if addTaskdef(td) {
 				log.Printf("[info] taskdef %s is used by %s", td.String(), ts.Resource)
 			}
-			for _, c := range task.Containers {
-				if c.Image == nil {
+			for _, cn := range task.Containers {
+				if cn.Image == nil {
 					continue
 				}
-				u := ImageURI(aws.ToString(c.Image))
+				u := ImageURI(aws.ToString(cn.Image))
 				if !u.IsECRImage() {
 					continue
 				}
 				// ECR image
 				if u.IsDigestURI() {
-					if s.Images.Add(u, tdArn) {
-						log.Printf("[info] image %s is used by %s container on %s", u.String(), *c.Name, ts.Resource)
+					if s.Images.AddConsumer(u, c) {
+						log.Printf("[info] image %s is used by %s container on %s", u.String(), *cn.Name, ts.Resource)
 					}
-				} else if c.ImageDigest != nil {
+				} else if cn.ImageDigest != nil {
 					base := u.Base()
-					digest := aws.ToString(c.ImageDigest)
+					digest := aws.ToString(cn.ImageDigest)
 					u := ImageURI(base + "@" + digest)
-					if s.Images.Add(u, tdArn) {
-						log.Printf("[info] image %s is used by %s container on %s", u.String(), *c.Name, ts.Resource)
+					if s.Images.AddConsumer(u, c) {
+						log.Printf("[info] image %s is used by %s container on %s", u.String(), *cn.Name, ts.Resource)
 					}
 				}
 			}
@@ -280,25 +469,14 @@ func (s *Scanner) availableResourcesInCluster(ctx context.Context, clusterArn st
 		}
 		for _, sv := range svs.Services {
 			log.Printf("[debug] Checking service %s", *sv.ServiceName)
-			for _, dp := range sv.Deployments {
-				tdArn := aws.ToString(dp.TaskDefinition)
-				td, err := parseTaskdefArn(tdArn)
-				if err != nil {
-					return nil, err
-				}
-				if tdArns.add(tdArn) {
-					log.Printf("[info] taskdef %s is used by %s deployment on service %s/%s", td.String(), *dp.Status, *sv.ServiceName, clusterName)
-				}
+			_tds, err := serviceTaskdefs(sv, clusterName)
+			if err != nil {
+				return nil, err
+			}
+			for _, td := range _tds {
+				addTaskdef(td)
 			}
 		}
 	}
-	var tds []taskdef
-	for a := range tdArns {
-		td, err := parseTaskdefArn(a)
-		if err != nil {
-			return nil, err
-		}
-		tds = append(tds, td)
-	}
 	return tds, nil
 }
@@ -0,0 +1,214 @@
+package ecrm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/scheduler"
+	"github.com/aws/smithy-go"
+)
+
+// taskdefDescriber is an interface of ECS client to describe task definitions.
+type taskdefDescriber interface {
+	DescribeTaskDefinition(ctx context.Context, params *ecs.DescribeTaskDefinitionInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTaskDefinitionOutput, error)
+}
+
+// eventBridgeClient is an interface of EventBridge client to list rules and their targets.
+type eventBridgeClient interface {
+	ListEventBuses(ctx context.Context, params *eventbridge.ListEventBusesInput, optFns ...func(*eventbridge.Options)) (*eventbridge.ListEventBusesOutput, error)
+	ListRules(ctx context.Context, params *eventbridge.ListRulesInput, optFns ...func(*eventbridge.Options)) (*eventbridge.ListRulesOutput, error)
+	ListTargetsByRule(ctx context.Context, params *eventbridge.ListTargetsByRuleInput, optFns ...func(*eventbridge.Options)) (*eventbridge.ListTargetsByRuleOutput, error)
+}
+
+// schedulerClient is an interface of EventBridge Scheduler client to list schedules.
+type schedulerClient interface {
+	scheduler.ListSchedulesAPIClient
+	GetSchedule(ctx context.Context, params *scheduler.GetScheduleInput, optFns ...func(*scheduler.Options)) (*scheduler.GetScheduleOutput, error)
+}
+
+// scanScheduledTasks returns task definitions of the scheduled tasks in the clusters.
+// Scheduled tasks are defined by the targets of EventBridge rules and EventBridge Scheduler schedules,
+// and are not found by scanning tasks unless they are running.
+func (s *Scanner) scanScheduledTasks(ctx context.Context, ccs []*ClusterConfig) ([]taskdef, error) {
+	if len(ccs) == 0 {
+		return nil, nil
+	}
+	var tds []taskdef
+	if _tds, err := s.scanEventBridgeRules(ctx, ccs); isAccessDenied(err) {
+		return nil, fmt.Errorf("%w. grant events:ListEventBuses, events:ListRules and events:ListTargetsByRule to keep the images of scheduled tasks, or set skip_scheduled_tasks", err)
+	} else if err != nil {
+		return nil, err
+	} else {
+		tds = append(tds, _tds...)
+	}
+	if _tds, err := s.scanSchedules(ctx, ccs); isAccessDenied(err) {
+		return nil, fmt.Errorf("%w. grant scheduler:ListSchedules and scheduler:GetSchedule to keep the images of scheduled tasks, or set skip_scheduled_tasks", err)
+	} else if err != nil {
+		return nil, err
+	} else {
+		tds = append(tds, _tds...)
+	}
+	return tds, nil
+}
+
+// isAccessDenied reports whether the error is caused by missing permissions.
+// The scan of scheduled tasks requires the permissions not required by older versions.
+func isAccessDenied(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "AccessDeniedException", "AccessDenied":
+		return true
+	}
+	return false
+}
+
+func matchClusters(ccs []*ClusterConfig, clusterArn string) bool {
+	for _, cc := range ccs {
+		if cc.Match(clusterArn) {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduledTaskdef returns the task definition run by the scheduled task.
+// The task definition ARN without a revision runs the latest ACTIVE revision.
+func scheduledTaskdef(ctx context.Context, client taskdefDescriber, tdArn string, c Consumer) (taskdef, error) {
+	td, err := parseTaskdefArn(tdArn)
+	if err != nil {
+		out, derr := client.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+			TaskDefinition: &tdArn,
+		})
+		if derr != nil {
+			return taskdef{}, fmt.Errorf("failed to describe task definition %s: %w", tdArn, derr)
+		}
+		tdArn = aws.ToString(out.TaskDefinition.TaskDefinitionArn)
+		if td, err = parseTaskdefArn(tdArn); err != nil {
+			return taskdef{}, err
+		}
+	}
+	td.usedBy = c
+	return td, nil
+}
+
+// scanEventBridgeRules returns task definitions of ECS targets of EventBridge rules in all event buses.
+func (s *Scanner) scanEventBridgeRules(ctx context.Context, ccs []*ClusterConfig) ([]taskdef, error) {
+	var buses []string
+	var nextToken *string
+	for {
+		out, err := s.eventbridge.ListEventBuses(ctx, &eventbridge.ListEventBusesInput{NextToken: nextToken})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list event buses: %w", err)
+		}
+		for _, b := range out.EventBuses {
+			buses = append(buses, aws.ToString(b.Name))
+		}
+		if nextToken = out.NextToken; nextToken == nil {
+			break
+		}
+	}
+
+	var tds []taskdef
+	for _, bus := range buses {
+		var nextToken *string
+		for {
+			out, err := s.eventbridge.ListRules(ctx, &eventbridge.ListRulesInput{
+				EventBusName: &bus,
+				NextToken:    nextToken,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to list rules in event bus %s: %w", bus, err)
+			}
+			for _, rule := range out.Rules {
+				_tds, err := s.scanEventBridgeRuleTargets(ctx, ccs, bus, aws.ToString(rule.Name), aws.ToString(rule.Arn))
+				if err != nil {
+					return nil, err
+				}
+				tds = append(tds, _tds...)
+			}
+			if nextToken = out.NextToken; nextToken == nil {
+				break
+			}
+		}
+	}
+	return tds, nil
+}
+
+func (s *Scanner) scanEventBridgeRuleTargets(ctx context.Context, ccs []*ClusterConfig, bus, rule, ruleArn string) ([]taskdef, error) {
+	var tds []taskdef
+	var nextToken *string
+	for {
+		out, err := s.eventbridge.ListTargetsByRule(ctx, &eventbridge.ListTargetsByRuleInput{
+			EventBusName: &bus,
+			Rule:         &rule,
+			NextToken:    nextToken,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list targets of rule %s: %w", ruleArn, err)
+		}
+		for _, t := range out.Targets {
+			clusterArn := aws.ToString(t.Arn)
+			if t.EcsParameters == nil || !matchClusters(ccs, clusterArn) {
+				continue
+			}
+			c := newConsumer(ConsumerTypeEventBridgeRule, ruleArn)
+			c.Cluster = clusterArnToName(clusterArn)
+			td, err := scheduledTaskdef(ctx, s.ecs, aws.ToString(t.EcsParameters.TaskDefinitionArn), c)
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("[info] taskdef %s is used by EventBridge rule %s", td.String(), ruleArn)
+			tds = append(tds, td)
+		}
+		if nextToken = out.NextToken; nextToken == nil {
+			break
+		}
+	}
+	return tds, nil
+}
+
+// scanSchedules returns task definitions of ECS targets of EventBridge Scheduler schedules in all schedule groups.
+func (s *Scanner) scanSchedules(ctx context.Context, ccs []*ClusterConfig) ([]taskdef, error) {
+	var tds []taskdef
+	p := scheduler.NewListSchedulesPaginator(s.scheduler, &scheduler.ListSchedulesInput{})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list schedules: %w", err)
+		}
+		for _, sc := range out.Schedules {
+			if sc.Target == nil || !matchClusters(ccs, aws.ToString(sc.Target.Arn)) {
+				continue
+			}
+			schedule, err := s.scheduler.GetSchedule(ctx, &scheduler.GetScheduleInput{
+				Name:      sc.Name,
+				GroupName: sc.GroupName,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get schedule %s: %w", aws.ToString(sc.Arn), err)
+			}
+			t := schedule.Target
+			if t == nil || t.EcsParameters == nil {
+				continue
+			}
+			scheduleArn := aws.ToString(schedule.Arn)
+			c := newConsumer(ConsumerTypeSchedulerSchedule, scheduleArn)
+			c.Cluster = clusterArnToName(aws.ToString(t.Arn))
+			td, err := scheduledTaskdef(ctx, s.ecs, aws.ToString(t.EcsParameters.TaskDefinitionArn), c)
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("[info] taskdef %s is used by EventBridge Scheduler schedule %s", td.String(), scheduleArn)
+			tds = append(tds, td)
+		}
+	}
+	return tds, nil
+}
@@ -0,0 +1,198 @@
+package ecrm_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebTypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/scheduler"
+	schedulerTypes "github.com/aws/aws-sdk-go-v2/service/scheduler/types"
+	"github.com/aws/smithy-go"
+	"github.com/fujiwara/ecrm"
+	"github.com/google/go-cmp/cmp"
+)
+
+const (
+	testTaskdefArnPrefix = "arn:aws:ecs:ap-northeast-1:012345678901:task-definition/"
+	testClusterArnPrefix = "arn:aws:ecs:ap-northeast-1:012345678901:cluster/"
+)
+
+type fakeTaskdefDescriber struct {
+	latest map[string]string
+	calls  int
+}
+
+func (f *fakeTaskdefDescriber) DescribeTaskDefinition(ctx context.Context, in *ecs.DescribeTaskDefinitionInput, _ ...func(*ecs.Options)) (*ecs.DescribeTaskDefinitionOutput, error) {
+	f.calls++
+	tdArn, found := f.latest[aws.ToString(in.TaskDefinition)]
+	if !found {
+		return nil, errors.New("task definition not found")
+	}
+	return &ecs.DescribeTaskDefinitionOutput{
+		TaskDefinition: &ecsTypes.TaskDefinition{TaskDefinitionArn: aws.String(tdArn)},
+	}, nil
+}
+
+func TestScheduledTaskdef(t *testing.T) {
+	tests := []struct {
+		name  string
+		arn   string
+		want  string
+		calls int
+		ok    bool
+	}{
+		{"with revision", testTaskdefArnPrefix + "app:3", "app:3", 0, true},
+		{"without revision", testTaskdefArnPrefix + "app", "app:7", 1, true},
+		{"not found", testTaskdefArnPrefix + "missing", "", 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeTaskdefDescriber{latest: map[string]string{
+				testTaskdefArnPrefix + "app": testTaskdefArnPrefix + "app:7",
+			}}
+			got, err := ecrm.ScheduledTaskdef(t.Context(), client, tt.arn)
+			if tt.ok != (err == nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.ok && got != tt.want {
+				t.Errorf("unexpected task definition: %s", got)
+			}
+			if client.calls != tt.calls {
+				t.Errorf("unexpected DescribeTaskDefinition calls: %d", client.calls)
+			}
+		})
+	}
+}
+
+type fakeEventBridge struct {
+	rules   map[string][]ebTypes.Rule   // event bus -> rules
+	targets map[string][]ebTypes.Target // rule name -> targets
+	err     error
+}
+
+func (f *fakeEventBridge) ListEventBuses(ctx context.Context, in *eventbridge.ListEventBusesInput, _ ...func(*eventbridge.Options)) (*eventbridge.ListEventBusesOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	out := &eventbridge.ListEventBusesOutput{}
+	for bus := range f.rules {
+		out.EventBuses = append(out.EventBuses, ebTypes.EventBus{Name: aws.String(bus)})
+	}
+	return out, nil
+}
+
+func (f *fakeEventBridge) ListRules(ctx context.Context, in *eventbridge.ListRulesInput, _ ...func(*eventbridge.Options)) (*eventbridge.ListRulesOutput, error) {
+	return &eventbridge.ListRulesOutput{Rules: f.rules[aws.ToString(in.EventBusName)]}, nil
+}
+
+func (f *fakeEventBridge) ListTargetsByRule(ctx context.Context, in *eventbridge.ListTargetsByRuleInput, _ ...func(*eventbridge.Options)) (*eventbridge.ListTargetsByRuleOutput, error) {
+	return &eventbridge.ListTargetsByRuleOutput{Targets: f.targets[aws.ToString(in.Rule)]}, nil
+}
+
+type fakeScheduler struct {
+	schedules map[string]*scheduler.GetScheduleOutput // name -> schedule
+	gets      []string
+}
+
+func (f *fakeScheduler) ListSchedules(ctx context.Context, in *scheduler.ListSchedulesInput, _ ...func(*scheduler.Options)) (*scheduler.ListSchedulesOutput, error) {
+	out := &scheduler.ListSchedulesOutput{}
+	for name, sc := range f.schedules {
+		out.Schedules = append(out.Schedules, schedulerTypes.ScheduleSummary{
+			Name:      aws.String(name),
+			GroupName: aws.String("default"),
+			Arn:       sc.Arn,
+			Target:    &schedulerTypes.TargetSummary{Arn: sc.Target.Arn},
+		})
+	}
+	return out, nil
+}
+
+func (f *fakeScheduler) GetSchedule(ctx context.Context, in *scheduler.GetScheduleInput, _ ...func(*scheduler.Options)) (*scheduler.GetScheduleOutput, error) {
+	f.gets = append(f.gets, aws.ToString(in.Name))
+	return f.schedules[aws.ToString(in.Name)], nil
+}
+
+func TestScanScheduledTasks(t *testing.T) {
+	ecsTarget := func(cluster, taskdef string) ebTypes.Target {
+		return ebTypes.Target{
+			Arn:           aws.String(testClusterArnPrefix + cluster),
+			EcsParameters: &ebTypes.EcsParameters{TaskDefinitionArn: aws.String(testTaskdefArnPrefix + taskdef)},
+		}
+	}
+	schedule := func(name, cluster, taskdef string) *scheduler.GetScheduleOutput {
+		return &scheduler.GetScheduleOutput{
+			Arn: aws.String("arn:aws:scheduler:ap-northeast-1:012345678901:schedule/default/" + name),
+			Target: &schedulerTypes.Target{
+				Arn:           aws.String(testClusterArnPrefix + cluster),
+				EcsParameters: &schedulerTypes.EcsParameters{TaskDefinitionArn: aws.String(testTaskdefArnPrefix + taskdef)},
+			},
+		}
+	}
+	const ruleArn = "arn:aws:events:ap-northeast-1:012345678901:rule/nightly"
+	eb := &fakeEventBridge{
+		rules: map[string][]ebTypes.Rule{
+			"default": {
+				{Name: aws.String("nightly"), Arn: aws.String(ruleArn)},
+				{Name: aws.String("notify"), Arn: aws.String("arn:aws:events:ap-northeast-1:012345678901:rule/notify")},
+			},
+		},
+		targets: map[string][]ebTypes.Target{
+			"nightly": {ecsTarget("prod", "nightly:3"), ecsTarget("dev", "nightly-dev:1")},
+			"notify":  {{Arn: aws.String("arn:aws:lambda:ap-northeast-1:012345678901:function:notify")}},
+		},
+	}
+	sc := &fakeScheduler{
+		schedules: map[string]*scheduler.GetScheduleOutput{
+			"report":     schedule("report", "prod", "report:5"),
+			"report-dev": schedule("report-dev", "dev", "report-dev:2"),
+		},
+	}
+	clusters := []*ecrm.ClusterConfig{{Name: "prod"}}
+	for _, cc := range clusters {
+		if err := cc.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tds, err := ecrm.NewScheduledTaskScanner(eb, sc).ScanScheduledTasks(t.Context(), clusters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]ecrm.Consumer{
+		"nightly:3": {
+			Type: ecrm.ConsumerTypeEventBridgeRule, Source: ruleArn,
+			Cluster: "prod", Account: "012345678901", Region: "ap-northeast-1",
+		},
+		"report:5": {
+			Type: ecrm.ConsumerTypeSchedulerSchedule, Source: "arn:aws:scheduler:ap-northeast-1:012345678901:schedule/default/report",
+			Cluster: "prod", Account: "012345678901", Region: "ap-northeast-1",
+		},
+	}
+	if diff := cmp.Diff(expected, tds); diff != "" {
+		t.Errorf("unexpected task definitions (-want +got):\n%s", diff)
+	}
+	// schedules in the other clusters are filtered out before GetSchedule
+	if diff := cmp.Diff([]string{"report"}, sc.gets); diff != "" {
+		t.Errorf("unexpected GetSchedule calls (-want +got):\n%s", diff)
+	}
+
+	// missing permissions fail the scan not to lose the images of scheduled tasks
+	eb.err = &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "not authorized"}
+	if _, err := ecrm.NewScheduledTaskScanner(eb, sc).ScanScheduledTasks(t.Context(), clusters); err == nil {
+		t.Error("expected an error for access denied")
+	} else if !strings.Contains(err.Error(), "skip_scheduled_tasks") {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	// other errors fail the scan
+	eb.err = errors.New("internal error")
+	if _, err := ecrm.NewScheduledTaskScanner(eb, sc).ScanScheduledTasks(t.Context(), clusters); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,178 @@
+package ecrm
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/k1LoW/duration"
+)
+
+// KeepSemverConfig keeps the latest images of each release line by semantic versions of tags.
+type KeepSemverConfig struct {
+	LatestPerMinor    int    `yaml:"latest_per_minor,omitempty"`
+	LatestPerMajor    int    `yaml:"latest_per_major,omitempty"`
+	MaxAge            string `yaml:"max_age,omitempty"`
+	IncludePrerelease bool   `yaml:"include_prerelease,omitempty"`
+
+	maxAgeBefore time.Time
+}
+
+func (c *KeepSemverConfig) Validate() error {
+	if c.LatestPerMinor < 0 || c.LatestPerMajor < 0 {
+		return errors.New("keep_semver latest_per_minor and latest_per_major must not be negative")
+	}
+	if c.LatestPerMinor == 0 && c.LatestPerMajor == 0 {
+		return errors.New("keep_semver latest_per_minor or latest_per_major is required")
+	}
+	if c.MaxAge != "" {
+		d, err := duration.Parse(c.MaxAge)
+		if err != nil {
+			return fmt.Errorf("keep_semver invalid max_age: %w", err)
+		}
+		c.maxAgeBefore = time.Now().Add(-d)
+	}
+	return nil
+}
+
+// keptDigests returns a map of image digests kept by keep_semver to the reasons.
+// Images are ranked by semantic versions of their tags, regardless of the pushed time.
+// Pre-release tags are ignored unless include_prerelease is set.
+func (c *KeepSemverConfig) keptDigests(details []ecrTypes.ImageDetail) map[string]string {
+	kept := make(map[string]string)
+	if c == nil {
+		return kept
+	}
+	var versions []*taggedVersion
+	for _, d := range details {
+		if c.MaxAge != "" && aws.ToTime(d.ImagePushedAt).Before(c.maxAgeBefore) {
+			continue
+		}
+		var latest *taggedVersion
+		for _, tag := range d.ImageTags {
+			v, ok := parseSemver(tag)
+			if !ok || (len(v.prerelease) > 0 && !c.IncludePrerelease) {
+				continue
+			}
+			if latest == nil || v.compare(latest.version) > 0 {
+				latest = &taggedVersion{version: v, tag: tag, digest: aws.ToString(d.ImageDigest)}
+			}
+		}
+		if latest != nil {
+			versions = append(versions, latest)
+		}
+	}
+	// newest first
+	slices.SortStableFunc(versions, func(a, b *taggedVersion) int {
+		return b.version.compare(a.version)
+	})
+
+	keep := func(n int, setting string, line func(v semver) string) {
+		if n <= 0 {
+			return
+		}
+		counts := make(map[string]int)
+		for _, tv := range versions {
+			l := line(tv.version)
+			counts[l]++
+			if counts[l] > n {
+				continue
+			}
+			if _, found := kept[tv.digest]; !found {
+				kept[tv.digest] = fmt.Sprintf("%s is within the latest %d of %s by keep_semver %s", tv.tag, n, l, setting)
+			}
+		}
+	}
+	keep(c.LatestPerMinor, "latest_per_minor", func(v semver) string {
+		return fmt.Sprintf("%d.%d", v.major, v.minor)
+	})
+	keep(c.LatestPerMajor, "latest_per_major", func(v semver) string {
+		return fmt.Sprintf("%d", v.major)
+	})
+	return kept
+}
+
+type taggedVersion struct {
+	version semver
+	tag     string
+	digest  string
+}
+
+// semver is a semantic version parsed from an image tag.
+type semver struct {
+	major, minor, patch int
+	prerelease          []string
+}
+
+var semverRegexp = regexp.MustCompile(`^v?(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// parseSemver parses a tag like "v1.2.3" or "1.2.3-rc1" as a semantic version.
+func parseSemver(tag string) (semver, bool) {
+	m := semverRegexp.FindStringSubmatch(tag)
+	if m == nil {
+		return semver{}, false
+	}
+	var v semver
+	for i, p := range []*int{&v.major, &v.minor, &v.patch} {
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return semver{}, false
+		}
+		*p = n
+	}
+	if m[4] != "" {
+		v.prerelease = strings.Split(m[4], ".")
+	}
+	return v, true
+}
+
+// compare compares the versions by the precedence of semantic versioning.
+func (v semver) compare(o semver) int {
+	if c := cmp.Compare(v.major, o.major); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.minor, o.minor); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.patch, o.patch); c != 0 {
+		return c
+	}
+	// a pre-release version has lower precedence than the normal version
+	switch {
+	case len(v.prerelease) == 0 && len(o.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(o.prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.prerelease) && i < len(o.prerelease); i++ {
+		if c := comparePrerelease(v.prerelease[i], o.prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(v.prerelease), len(o.prerelease))
+}
+
+// comparePrerelease compares pre-release identifiers.
+// Numeric identifiers are compared numerically and have lower precedence than alphanumeric ones.
+func comparePrerelease(a, b string) int {
+	an, aErr := strconv.Atoi(a)
+	bn, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return cmp.Compare(an, bn)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,90 @@
+package ecrm_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/fujiwara/ecrm"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestKeepSemverKeptDigests(t *testing.T) {
+	now := time.Now()
+	detail := func(digest string, age time.Duration, tags ...string) ecrTypes.ImageDetail {
+		return ecrTypes.ImageDetail{
+			ImageDigest:   aws.String(digest),
+			ImageTags:     tags,
+			ImagePushedAt: aws.Time(now.Add(-age)),
+		}
+	}
+	day := 24 * time.Hour
+	// pushed order differs from the version order (hotfixes for old release lines)
+	details := []ecrTypes.ImageDetail{
+		detail("sha256:a", 1*day, "v1.1.5"),
+		detail("sha256:b", 2*day, "v2.0.1"),
+		detail("sha256:c", 3*day, "v1.1.4"),
+		detail("sha256:d", 4*day, "v2.0.0"),
+		detail("sha256:e", 5*day, "v1.1.3"),
+		detail("sha256:f", 6*day, "v2.0.2-rc1"),
+		detail("sha256:g", 7*day, "1.0.9", "latest"),
+		detail("sha256:h", 8*day, "main"),
+		detail("sha256:i", 400*day, "v3.0.0"),
+	}
+	tests := []struct {
+		name   string
+		config ecrm.KeepSemverConfig
+		kept   []string
+	}{
+		{
+			name:   "latest_per_minor",
+			config: ecrm.KeepSemverConfig{LatestPerMinor: 2},
+			kept:   []string{"sha256:a", "sha256:b", "sha256:c", "sha256:d", "sha256:g", "sha256:i"},
+		},
+		{
+			name:   "latest_per_major",
+			config: ecrm.KeepSemverConfig{LatestPerMajor: 1},
+			kept:   []string{"sha256:a", "sha256:b", "sha256:i"},
+		},
+		{
+			name:   "include_prerelease",
+			config: ecrm.KeepSemverConfig{LatestPerMajor: 1, IncludePrerelease: true},
+			kept:   []string{"sha256:a", "sha256:f", "sha256:i"},
+		},
+		{
+			name:   "max_age",
+			config: ecrm.KeepSemverConfig{LatestPerMajor: 1, MaxAge: "365d"},
+			kept:   []string{"sha256:a", "sha256:b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			digests := tt.config.KeptDigests(details)
+			var kept []string
+			for _, d := range details {
+				if _, found := digests[*d.ImageDigest]; found {
+					kept = append(kept, *d.ImageDigest)
+				}
+			}
+			if diff := cmp.Diff(tt.kept, kept); diff != "" {
+				t.Errorf("unexpected kept digests (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestKeepSemverValidate(t *testing.T) {
+	for _, c := range []ecrm.KeepSemverConfig{
+		{},
+		{LatestPerMinor: -1, LatestPerMajor: 1},
+		{LatestPerMinor: 1, MaxAge: "foo"},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("expected error for %#v", c)
+		}
+	}
+}
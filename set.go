@@ -1,5 +1,7 @@
 package ecrm
 
+import "slices"
+
 type set map[string]struct{}
 
 func newSet(members ...string) set {
@@ -42,6 +44,12 @@ func (s set) members() []string {
 	return members
 }
 
+func (s set) sortedMembers() []string {
+	members := s.members()
+	slices.Sort(members)
+	return members
+}
+
 func (s set) union(o set) set {
 	if o == nil {
 		return s
@@ -0,0 +1,329 @@
+package ecrm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Songmu/prompter"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	ociTypes "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/k1LoW/duration"
+)
+
+const (
+	quarantineTagPrefix  = "ecrm-expired-"
+	restoredTagPrefix    = "ecrm-restored-"
+	quarantineDateFormat = "20060102"
+)
+
+var DefaultGracePeriod = "7d"
+
+// SoftDeleteAction represents what to do with an image in the soft delete mode.
+type SoftDeleteAction string
+
+const (
+	SoftDeleteQuarantine SoftDeleteAction = "quarantine"
+	SoftDeleteWait       SoftDeleteAction = "wait"
+	SoftDeleteDelete     SoftDeleteAction = "delete"
+	SoftDeleteRestore    SoftDeleteAction = "restore"
+)
+
+// SoftDeleteConfig enables two-phase deletion. Expired images are quarantined by a tag at first,
+// and deleted after the grace period if they are still expired.
+type SoftDeleteConfig struct {
+	GracePeriod string `yaml:"grace_period,omitempty"`
+
+	gracePeriod time.Duration
+}
+
+func (c *SoftDeleteConfig) Validate() error {
+	if c.GracePeriod == "" {
+		log.Printf("[warn] soft_delete grace_period is not defined. set default grace_period to %s", DefaultGracePeriod)
+		c.GracePeriod = DefaultGracePeriod
+	}
+	d, err := duration.Parse(c.GracePeriod)
+	if err != nil {
+		return fmt.Errorf("invalid soft_delete grace_period: %w", err)
+	}
+	c.gracePeriod = d
+	return nil
+}
+
+// quarantineTag returns a quarantine tag of the image. The tag has the date (UTC) and the digest because tags must be unique in a repository.
+func quarantineTag(digest string, at time.Time) string {
+	return quarantineTagPrefix + at.UTC().Format(quarantineDateFormat) + "-" + digestHex(digest)
+}
+
+// restoredTag returns a tag to keep the restored image that has no other tags.
+func restoredTag(digest string) string {
+	return restoredTagPrefix + digestHex(digest)
+}
+
+func digestHex(digest string) string {
+	_, hex, _ := strings.Cut(shortDigest(digest), ":")
+	return hex
+}
+
+// parseQuarantineTag returns the date when the image was quarantined.
+func parseQuarantineTag(tag string) (time.Time, bool) {
+	s, found := strings.CutPrefix(tag, quarantineTagPrefix)
+	if !found || len(s) < len(quarantineDateFormat) {
+		return time.Time{}, false
+	}
+	at, err := time.Parse(quarantineDateFormat, s[:len(quarantineDateFormat)])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return at, true
+}
+
+// isSystemTag reports whether the tag is added by ecrm.
+func isSystemTag(tag string) bool {
+	_, quarantine := parseQuarantineTag(tag)
+	return quarantine || strings.HasPrefix(tag, restoredTagPrefix)
+}
+
+// stripSystemTags removes tags added by ecrm from the image details so that they do not affect decisions.
+// Returns a map of image digests to the earliest date when the image was quarantined.
+func stripSystemTags(details []ecrTypes.ImageDetail) map[string]time.Time {
+	quarantined := make(map[string]time.Time)
+	for i, d := range details {
+		if !slices.ContainsFunc(d.ImageTags, isSystemTag) {
+			continue
+		}
+		tags := make([]string, 0, len(d.ImageTags))
+		for _, tag := range d.ImageTags {
+			if at, ok := parseQuarantineTag(tag); ok {
+				digest := aws.ToString(d.ImageDigest)
+				if prev, found := quarantined[digest]; !found || at.Before(prev) {
+					quarantined[digest] = at
+				}
+			}
+			if !isSystemTag(tag) {
+				tags = append(tags, tag)
+			}
+		}
+		details[i].ImageTags = tags
+	}
+	return quarantined
+}
+
+// applySoftDelete splits the expired images into images to be quarantined, to wait for the grace period and to be deleted.
+// Kept images that have been quarantined are restored.
+func (r *PlanResult) applySoftDelete(sc *SoftDeleteConfig, now time.Time) {
+	r.Quarantine = make(DeletableImageIDs)
+	r.Restore = make(DeletableImageIDs)
+	ready := make(map[RepositoryName]set)
+	waiting := make(map[RepositoryName]set)
+	for _, d := range r.Decisions {
+		if ready[d.Repo] == nil {
+			ready[d.Repo], waiting[d.Repo] = newSet(), newSet()
+		}
+		id := ecrTypes.ImageIdentifier{ImageDigest: aws.String(d.Digest)}
+		quarantined := !d.QuarantinedAt.IsZero()
+		switch {
+		case !d.Expired && quarantined:
+			d.SoftDelete = SoftDeleteRestore
+			r.Restore[d.Repo] = append(r.Restore[d.Repo], id)
+		case !d.Expired, d.Archive:
+		case d.Status == string(ecrTypes.ImageStatusArchived):
+			// archived images have been kept in the archive storage class for delete_after
+			d.SoftDelete = SoftDeleteDelete
+			ready[d.Repo].add(d.Digest)
+		case !quarantined:
+			d.SoftDelete = SoftDeleteQuarantine
+			r.Quarantine[d.Repo] = append(r.Quarantine[d.Repo], id)
+			waiting[d.Repo].add(d.Digest)
+		case now.Sub(d.QuarantinedAt.AddDate(0, 0, 1)) < sc.gracePeriod:
+			// the quarantine tag has only the date, so the grace period starts at the end of the day
+			d.SoftDelete = SoftDeleteWait
+			waiting[d.Repo].add(d.Digest)
+		default:
+			d.SoftDelete = SoftDeleteDelete
+			ready[d.Repo].add(d.Digest)
+		}
+	}
+
+	// images referenced by waiting image indexes must wait too
+	for _, d := range r.Decisions {
+		if d.SoftDelete != SoftDeleteDelete {
+			continue
+		}
+		if parent, blocked := r.Dependencies[d.Repo].blockedBy(d.Digest, waiting[d.Repo]); blocked {
+			log.Printf("[info] %s@%s waits for %s referencing it", d.Repo, d.Digest, parent)
+			d.SoftDelete = SoftDeleteWait
+			ready[d.Repo].remove(d.Digest)
+		}
+	}
+
+	for repo, ids := range r.Deletable {
+		r.Deletable[repo] = slices.DeleteFunc(ids, func(id ecrTypes.ImageIdentifier) bool {
+			return !ready[repo].contains(aws.ToString(id.ImageDigest))
+		})
+	}
+}
+
+// quarantineImages adds quarantine tags to the images.
+func (app *App) quarantineImages(ctx context.Context, reg *registry, repo RepositoryName, ids []ecrTypes.ImageIdentifier, force bool) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if !force {
+		if !prompter.YN(fmt.Sprintf("Do you quarantine %d images on %s (%s)?", len(ids), repo, reg), false) {
+			return errors.New("aborted")
+		}
+	}
+	now := time.Now()
+	for _, id := range ids {
+		digest := aws.ToString(id.ImageDigest)
+		log.Printf("[notice] Quarantining %s %s (%s)", repo, digest, reg)
+		if err := putImageTag(ctx, reg.planner.ecr, repo, digest, quarantineTag(digest, now)); err != nil {
+			return fmt.Errorf("failed to quarantine %s@%s: %w", repo, digest, err)
+		}
+	}
+	log.Printf("[info] Quarantined %d images on %s (%s)", len(ids), repo, reg)
+	return nil
+}
+
+// restoreImages removes quarantine tags from the images.
+func (app *App) restoreImages(ctx context.Context, reg *registry, repo RepositoryName, ids []ecrTypes.ImageIdentifier) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	current, err := reg.planner.currentImages(ctx, repo)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		d, found := current[aws.ToString(id.ImageDigest)]
+		if !found {
+			continue
+		}
+		if err := restoreImage(ctx, reg.planner.ecr, repo, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreImage removes quarantine tags from the image.
+// ECR deletes an image when its last tag is removed, so the image having only quarantine tags is tagged by a restored tag before.
+func restoreImage(ctx context.Context, client *ecr.Client, repo RepositoryName, d ecrTypes.ImageDetail) error {
+	digest := aws.ToString(d.ImageDigest)
+	var quarantineTags []ecrTypes.ImageIdentifier
+	for _, tag := range d.ImageTags {
+		if _, ok := parseQuarantineTag(tag); ok {
+			quarantineTags = append(quarantineTags, ecrTypes.ImageIdentifier{ImageTag: aws.String(tag)})
+		}
+	}
+	if len(quarantineTags) == 0 {
+		return nil
+	}
+	log.Printf("[notice] Restoring %s %s", repo, digest)
+	if len(quarantineTags) == len(d.ImageTags) {
+		if err := putImageTag(ctx, client, repo, digest, restoredTag(digest)); err != nil {
+			return fmt.Errorf("failed to restore %s@%s: %w", repo, digest, err)
+		}
+	}
+	out, err := client.BatchDeleteImage(ctx, &ecr.BatchDeleteImageInput{
+		ImageIds:       quarantineTags,
+		RepositoryName: aws.String(string(repo)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove quarantine tags of %s@%s: %w", repo, digest, err)
+	}
+	for _, f := range out.Failures {
+		return fmt.Errorf("failed to remove quarantine tag %s of %s@%s: %s %s", aws.ToString(f.ImageId.ImageTag), repo, digest, f.FailureCode, aws.ToString(f.FailureReason))
+	}
+	return nil
+}
+
+// putImageTag adds the tag to the image by putting the existing manifest.
+func putImageTag(ctx context.Context, client *ecr.Client, repo RepositoryName, digest, tag string) error {
+	res, err := client.BatchGetImage(ctx, &ecr.BatchGetImageInput{
+		ImageIds:       []ecrTypes.ImageIdentifier{{ImageDigest: aws.String(digest)}},
+		RepositoryName: aws.String(string(repo)),
+		AcceptedMediaTypes: []string{
+			string(ociTypes.OCIManifestSchema1),
+			string(ociTypes.OCIImageIndex),
+			string(ociTypes.DockerManifestSchema1),
+			string(ociTypes.DockerManifestSchema2),
+			string(ociTypes.DockerManifestList),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get manifest: %w", err)
+	}
+	if len(res.Images) == 0 {
+		for _, f := range res.Failures {
+			return fmt.Errorf("failed to get manifest: %s %s", f.FailureCode, aws.ToString(f.FailureReason))
+		}
+		return errors.New("manifest not found")
+	}
+	img := res.Images[0]
+	_, err = client.PutImage(ctx, &ecr.PutImageInput{
+		RepositoryName:         aws.String(string(repo)),
+		ImageManifest:          img.ImageManifest,
+		ImageManifestMediaType: img.ImageManifestMediaType,
+		ImageDigest:            aws.String(digest),
+		ImageTag:               aws.String(tag),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put image tag %s: %w", tag, err)
+	}
+	return nil
+}
+
+// Restore removes quarantine tags from images in the repositories managed by ecrm.
+func (app *App) Restore(ctx context.Context, path string, opt *Option) error {
+	c, err := LoadConfig(path)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	regs, err := app.registries(ctx, c)
+	if err != nil {
+		return err
+	}
+	for _, reg := range regs {
+		repos, err := reg.planner.repositories(ctx, c.repositoriesFor(reg.name), opt.Repository)
+		if err != nil {
+			return fmt.Errorf("failed to list repositories on registry %s: %w", reg, err)
+		}
+		for _, repo := range repos {
+			name := RepositoryName(aws.ToString(repo.RepositoryName))
+			current, err := reg.planner.currentImages(ctx, name)
+			if err != nil {
+				return fmt.Errorf("failed to list images in %s on registry %s: %w", name, reg, err)
+			}
+			var quarantined []ecrTypes.ImageDetail
+			for _, d := range current {
+				if _, found := stripSystemTags([]ecrTypes.ImageDetail{d})[aws.ToString(d.ImageDigest)]; found {
+					quarantined = append(quarantined, d)
+				}
+			}
+			if len(quarantined) == 0 {
+				log.Printf("[info] no quarantined images on %s (%s)", name, reg)
+				continue
+			}
+			if !opt.Force {
+				if !prompter.YN(fmt.Sprintf("Do you restore %d images on %s (%s)?", len(quarantined), name, reg), false) {
+					return errors.New("aborted")
+				}
+			}
+			for _, d := range quarantined {
+				if err := restoreImage(ctx, reg.planner.ecr, name, d); err != nil {
+					return err
+				}
+			}
+			log.Printf("[info] Restored %d images on %s (%s)", len(quarantined), name, reg)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package ecrm_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/fujiwara/ecrm"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStripSystemTags(t *testing.T) {
+	digest := "sha256:0123456789abcdef0123"
+	at := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	tag := ecrm.QuarantineTag(digest, at)
+	if tag != "ecrm-expired-20261016-0123456789ab" {
+		t.Errorf("unexpected quarantine tag: %s", tag)
+	}
+	details := []ecrTypes.ImageDetail{
+		{ImageDigest: aws.String(digest), ImageTags: []string{"v1", tag, ecrm.QuarantineTag(digest, at.AddDate(0, 0, 1))}},
+		{ImageDigest: aws.String("sha256:bbb"), ImageTags: []string{"ecrm-restored-bbb"}},
+		{ImageDigest: aws.String("sha256:ccc"), ImageTags: []string{"ecrm-expired-latest"}},
+	}
+	quarantined := ecrm.StripSystemTags(details)
+	if diff := cmp.Diff(map[string]time.Time{digest: at}, quarantined); diff != "" {
+		t.Errorf("unexpected quarantined: %s", diff)
+	}
+	if diff := cmp.Diff([]string{"v1"}, details[0].ImageTags); diff != "" {
+		t.Errorf("unexpected tags: %s", diff)
+	}
+	if len(details[1].ImageTags) != 0 {
+		t.Errorf("restored tag should be stripped: %v", details[1].ImageTags)
+	}
+	if diff := cmp.Diff([]string{"ecrm-expired-latest"}, details[2].ImageTags); diff != "" {
+		t.Errorf("not a quarantine tag should be kept: %s", diff)
+	}
+}
+
+func TestApplySoftDelete(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	sc := &ecrm.SoftDeleteConfig{GracePeriod: "7d"}
+	if err := sc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	r := &ecrm.PlanResult{
+		Decisions: ecrm.ImageDecisions{
+			{Repo: "app", Digest: "sha256:new", Expired: true},
+			{Repo: "app", Digest: "sha256:waiting", Expired: true, QuarantinedAt: now.AddDate(0, 0, -3)},
+			{Repo: "app", Digest: "sha256:index", Expired: true, QuarantinedAt: now.AddDate(0, 0, -3)},
+			{Repo: "app", Digest: "sha256:child", Expired: true, QuarantinedAt: now.AddDate(0, 0, -10)},
+			{Repo: "app", Digest: "sha256:old", Expired: true, QuarantinedAt: now.AddDate(0, 0, -10)},
+			// quarantined at 2026-10-09T23:59:59 at the latest
+			{Repo: "app", Digest: "sha256:edge", Expired: true, QuarantinedAt: time.Date(2026, 10, 9, 0, 0, 0, 0, time.UTC)},
+			{Repo: "app", Digest: "sha256:used", Expired: false, QuarantinedAt: now.AddDate(0, 0, -10)},
+			{Repo: "app", Digest: "sha256:kept", Expired: false},
+		},
+		Deletable: ecrm.DeletableImageIDs{
+			"app": ids("sha256:index", "sha256:new", "sha256:waiting", "sha256:child", "sha256:old", "sha256:edge"),
+		},
+		Dependencies: map[ecrm.RepositoryName]ecrm.DeletionGraph{
+			"app": {"sha256:child": {"sha256:index"}},
+		},
+	}
+	r.ApplySoftDelete(sc, now)
+
+	actions := map[string]ecrm.SoftDeleteAction{}
+	for _, d := range r.Decisions {
+		actions[d.Digest] = d.SoftDelete
+	}
+	if diff := cmp.Diff(map[string]ecrm.SoftDeleteAction{
+		"sha256:new":     ecrm.SoftDeleteQuarantine,
+		"sha256:waiting": ecrm.SoftDeleteWait,
+		"sha256:index":   ecrm.SoftDeleteWait,
+		"sha256:child":   ecrm.SoftDeleteWait,
+		"sha256:old":     ecrm.SoftDeleteDelete,
+		"sha256:edge":    ecrm.SoftDeleteWait,
+		"sha256:used":    ecrm.SoftDeleteRestore,
+		"sha256:kept":    "",
+	}, actions); diff != "" {
+		t.Errorf("unexpected actions: %s", diff)
+	}
+	digests := func(ids ecrm.DeletableImageIDs) []string {
+		var ds []string
+		for _, id := range ids["app"] {
+			ds = append(ds, aws.ToString(id.ImageDigest))
+		}
+		return ds
+	}
+	if diff := cmp.Diff([]string{"sha256:old"}, digests(r.Deletable)); diff != "" {
+		t.Errorf("unexpected deletable: %s", diff)
+	}
+	if diff := cmp.Diff([]string{"sha256:new"}, digests(r.Quarantine)); diff != "" {
+		t.Errorf("unexpected quarantine: %s", diff)
+	}
+	if diff := cmp.Diff([]string{"sha256:used"}, digests(r.Restore)); diff != "" {
+		t.Errorf("unexpected restore: %s", diff)
+	}
+}
@@ -0,0 +1,153 @@
+package ecrm
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/k1LoW/duration"
+)
+
+// StreamGroupName is the name of the capture group that defines a stream in the streams pattern.
+const StreamGroupName = "stream"
+
+// StreamsConfig groups tagged images into streams (e.g. branches) by the tag pattern,
+// and applies keep_count and expires within each stream.
+type StreamsConfig struct {
+	Pattern   string `yaml:"pattern"`
+	Expires   string `yaml:"expires,omitempty"`
+	KeepCount *int64 `yaml:"keep_count,omitempty"`
+
+	re           *regexp.Regexp
+	group        int
+	expireBefore time.Time
+}
+
+func (c *StreamsConfig) Validate() error {
+	if c.Pattern == "" {
+		return errors.New("streams pattern is required")
+	}
+	re, err := regexp.Compile(c.Pattern)
+	if err != nil {
+		return fmt.Errorf("streams invalid pattern: %w", err)
+	}
+	if re.NumSubexp() == 0 {
+		return fmt.Errorf("streams pattern %s must have a capture group", c.Pattern)
+	}
+	c.re = re
+	// the named group "stream", or the first group
+	c.group = max(re.SubexpIndex(StreamGroupName), 1)
+	if c.KeepCount != nil && *c.KeepCount < 0 {
+		return errors.New("streams keep_count must not be negative")
+	}
+	if c.Expires != "" {
+		d, err := duration.Parse(c.Expires)
+		if err != nil {
+			return fmt.Errorf("streams invalid expires: %w", err)
+		}
+		c.expireBefore = time.Now().Add(-d)
+	}
+	return nil
+}
+
+// streamOf returns the stream name of the first tag matching the pattern.
+func (c *StreamsConfig) streamOf(tags []string) (string, bool) {
+	if c == nil || c.re == nil {
+		return "", false
+	}
+	for _, tag := range tags {
+		if m := c.re.FindStringSubmatch(tag); m != nil && m[c.group] != "" {
+			return m[c.group], true
+		}
+	}
+	return "", false
+}
+
+// untaggedStream is the key of keep_count for untagged images.
+// It never conflicts with stream names captured from tags, because tags cannot contain "<".
+const untaggedStream = "<untagged>"
+
+// retention is the expires and keep_count applied to an image.
+type retention struct {
+	// stream is the stream of the image. It is empty for images not in any stream.
+	stream       string
+	expires      string
+	expireBefore time.Time
+	keepCount    int64
+	// counted reports whether the image is counted in keep_count.
+	counted bool
+}
+
+// retentionFor returns the retention for the image tags.
+// Images in a stream use expires and keep_count of the streams (or the repository if not defined).
+// Untagged images use the untagged policy if defined, otherwise they are not counted in keep_count.
+func (r *RepositoryConfig) retentionFor(tags []string) retention {
+	rt := retention{
+		expires:      r.Expires,
+		expireBefore: r.expireBefore,
+		keepCount:    r.KeepCount,
+		counted:      len(tags) > 0,
+	}
+	if len(tags) == 0 {
+		if r.Untagged != nil {
+			rt.stream = untaggedStream
+			rt.keepCount = r.Untagged.KeepCount
+			rt.counted = true
+			if r.Untagged.Expires != "" {
+				rt.expires, rt.expireBefore = r.Untagged.Expires, r.Untagged.expireBefore
+			}
+		}
+		return rt
+	}
+	stream, found := r.Streams.streamOf(tags)
+	if !found {
+		return rt
+	}
+	rt.stream = stream
+	if r.Streams.Expires != "" {
+		rt.expires, rt.expireBefore = r.Streams.Expires, r.Streams.expireBefore
+	}
+	if r.Streams.KeepCount != nil {
+		rt.keepCount = *r.Streams.KeepCount
+	}
+	return rt
+}
+
+func (rt retention) isExpired(at time.Time) bool {
+	return at.Before(rt.expireBefore)
+}
+
+// of returns the suffix of messages for the stream.
+func (rt retention) of() string {
+	switch rt.stream {
+	case "":
+		return ""
+	case untaggedStream:
+		return " of untagged images"
+	}
+	return fmt.Sprintf(" of stream %s", rt.stream)
+}
+
+// UntaggedConfig is the retention policy for untagged images.
+// It also covers dangling images that were left untagged when their tags moved to other images.
+type UntaggedConfig struct {
+	Expires   string `yaml:"expires,omitempty"`
+	KeepCount int64  `yaml:"keep_count,omitempty"`
+
+	expireBefore time.Time
+}
+
+func (c *UntaggedConfig) Validate() error {
+	if c.KeepCount < 0 {
+		return errors.New("untagged keep_count must not be negative")
+	}
+	if c.Expires != "" {
+		d, err := duration.Parse(c.Expires)
+		if err != nil {
+			return fmt.Errorf("untagged invalid expires: %w", err)
+		}
+		c.expireBefore = time.Now().Add(-d)
+	}
+	return nil
+}
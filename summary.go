@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"slices"
 	"sort"
 	"strings"
 
@@ -64,13 +65,35 @@ func (s RepoSummary) Expire(img ecrTypes.ImageDetail) {
 	}
 }
 
+// Archive counts up the image to be archived.
+func (s RepoSummary) Archive(img ecrTypes.ImageDetail) {
+	index := s.toIndex(img)
+	if index >= 0 {
+		s[index].ArchivedImages++
+		s[index].ArchivedImageSize += aws.ToInt64(img.ImageSizeInBytes)
+	}
+}
+
+// OverBudget records the kept size over max_total_size that cannot be expired because the images are protected.
+func (s RepoSummary) OverBudget(size int64) {
+	s[0].OverBudgetSize = size
+}
+
 type Summary struct {
-	Repo             RepositoryName `json:"repository"`
-	Type             string         `json:"type"`
-	ExpiredImages    int64          `json:"expired_images"`
-	TotalImages      int64          `json:"total_images"`
-	ExpiredImageSize int64          `json:"expired_image_size"`
-	TotalImageSize   int64          `json:"total_image_size"`
+	Account           string         `json:"account,omitempty"`
+	Region            string         `json:"region,omitempty"`
+	Repo              RepositoryName `json:"repository"`
+	Type              string         `json:"type"`
+	ExpiredImages     int64          `json:"expired_images"`
+	TotalImages       int64          `json:"total_images"`
+	ExpiredImageSize  int64          `json:"expired_image_size"`
+	TotalImageSize    int64          `json:"total_image_size"`
+	ArchivedImages    int64          `json:"archived_images,omitempty"`
+	ArchivedImageSize int64          `json:"archived_image_size,omitempty"`
+	OverBudgetSize    int64          `json:"over_budget_size,omitempty"`
+
+	// archive is true if the repository is managed by action archive
+	archive bool
 }
 
 func (s *Summary) printable() bool {
@@ -80,14 +103,26 @@ func (s *Summary) printable() bool {
 	return true
 }
 
-func (s *Summary) row() []string {
-	return []string{
+func (s *Summary) row(archive, overBudget bool) []string {
+	row := []string{
 		string(s.Repo),
 		s.Type,
 		fmt.Sprintf("%d (%s)", s.TotalImages, humanize.Bytes(uint64(s.TotalImageSize))),
 		fmt.Sprintf("%d (%s)", -s.ExpiredImages, humanize.Bytes(uint64(s.ExpiredImageSize))),
-		fmt.Sprintf("%d (%s)", s.TotalImages-s.ExpiredImages, humanize.Bytes(uint64(s.TotalImageSize-s.ExpiredImageSize))),
 	}
+	if archive {
+		row = append(row, fmt.Sprintf("%d (%s)", s.ArchivedImages, humanize.Bytes(uint64(s.ArchivedImageSize))))
+	}
+	keep, keepSize := s.TotalImages-s.ExpiredImages-s.ArchivedImages, s.TotalImageSize-s.ExpiredImageSize-s.ArchivedImageSize
+	row = append(row, fmt.Sprintf("%d (%s)", keep, humanize.Bytes(uint64(keepSize))))
+	if overBudget {
+		if s.OverBudgetSize > 0 {
+			row = append(row, humanize.Bytes(uint64(s.OverBudgetSize)))
+		} else {
+			row = append(row, "")
+		}
+	}
+	return row
 }
 
 func newOutputFormatFrom(s string) outputFormat {
@@ -96,6 +131,8 @@ func newOutputFormatFrom(s string) outputFormat {
 		return formatTable
 	case "json":
 		return formatJSON
+	case "github":
+		return formatGitHub
 	default:
 		panic(fmt.Sprintf("invalid format name: %s", s))
 	}
@@ -109,6 +146,8 @@ func (f outputFormat) String() string {
 		return "table"
 	case formatJSON:
 		return "json"
+	case formatGitHub:
+		return "github"
 	default:
 		return "unknown"
 	}
@@ -117,20 +156,42 @@ func (f outputFormat) String() string {
 const (
 	formatTable outputFormat = iota + 1
 	formatJSON
+	formatGitHub
 )
 
 type SummaryTable []*Summary
 
 func (s SummaryTable) Sort() {
 	sort.SliceStable(s, func(i, j int) bool {
+		if s[i].Account != s[j].Account {
+			return s[i].Account < s[j].Account
+		}
+		if s[i].Region != s[j].Region {
+			return s[i].Region < s[j].Region
+		}
 		return s[i].Repo < s[j].Repo
 	})
 }
 
-func (s *SummaryTable) Print(w io.Writer, format outputFormat) error {
+// hasOverBudget reports whether some repositories cannot be kept under max_total_size.
+func (s SummaryTable) hasOverBudget() bool {
+	return lo.SomeBy(s, func(_s *Summary) bool {
+		return _s.OverBudgetSize > 0
+	})
+}
+
+// hasArchive reports whether the summaries have images managed by action archive.
+func (s SummaryTable) hasArchive() bool {
+	return lo.SomeBy(s, func(_s *Summary) bool {
+		return _s.archive || _s.ArchivedImages > 0
+	})
+}
+
+// Print prints the summaries. withRegistry adds the account and region columns to the table.
+func (s *SummaryTable) Print(w io.Writer, format outputFormat, withRegistry bool) error {
 	switch format {
 	case formatTable:
-		return s.printTable(w)
+		return s.printTable(w, withRegistry)
 	case formatJSON:
 		return s.printJSON(w)
 	default:
@@ -141,29 +202,52 @@ func (s *SummaryTable) Print(w io.Writer, format outputFormat) error {
 func (s SummaryTable) printJSON(w io.Writer) error {
 	enc := json.NewEncoder(w)
 	enc.SetIndent("", "  ")
-	ss := lo.Filter(s, func(_s *Summary, _ int) bool {
+	return enc.Encode(s.printables())
+}
+
+func (s SummaryTable) printables() SummaryTable {
+	return lo.Filter(s, func(_s *Summary, _ int) bool {
 		return _s.printable()
 	})
-	return enc.Encode(ss)
 }
 
-func (s SummaryTable) printTable(w io.Writer) error {
+func (s SummaryTable) printTable(w io.Writer, withRegistry bool) error {
 	t := tablewriter.NewWriter(w)
-	t.SetHeader(s.header())
+	archive, overBudget := s.hasArchive(), s.hasOverBudget()
+	header := s.header(archive, overBudget)
+	if withRegistry {
+		header = append([]string{"account", "region"}, header...)
+	}
+	t.SetHeader(header)
 	t.SetBorder(false)
 	for _, s := range s {
-		row := s.row()
+		row := s.row(archive, overBudget)
 		if !s.printable() {
 			continue
 		}
+		if withRegistry {
+			row = append([]string{s.Account, s.Region}, row...)
+		}
+		expired, keep := slices.Index(header, "expired"), slices.Index(header, "keep")
 		colors := make([]tablewriter.Colors, len(row))
-		if strings.HasPrefix(row[3], "0 ") {
-			row[3] = ""
+		if strings.HasPrefix(row[expired], "0 ") {
+			row[expired] = ""
 		} else {
-			colors[3] = tablewriter.Colors{tablewriter.FgBlueColor}
+			colors[expired] = tablewriter.Colors{tablewriter.FgBlueColor}
+		}
+		if archive {
+			archived := expired + 1
+			if strings.HasPrefix(row[archived], "0 ") {
+				row[archived] = ""
+			} else {
+				colors[archived] = tablewriter.Colors{tablewriter.FgCyanColor}
+			}
 		}
-		if strings.HasPrefix(row[4], "0 ") {
-			colors[4] = tablewriter.Colors{tablewriter.FgYellowColor}
+		if strings.HasPrefix(row[keep], "0 ") {
+			colors[keep] = tablewriter.Colors{tablewriter.FgYellowColor}
+		}
+		if s.OverBudgetSize > 0 {
+			colors[len(row)-1] = tablewriter.Colors{tablewriter.FgRedColor}
 		}
 		if color.NoColor {
 			t.Append(row)
@@ -175,12 +259,19 @@ func (s SummaryTable) printTable(w io.Writer) error {
 	return nil
 }
 
-func (s SummaryTable) header() []string {
-	return []string{
+func (s SummaryTable) header(archive, overBudget bool) []string {
+	header := []string{
 		"repository",
 		"type",
 		"total",
 		"expired",
-		"keep",
 	}
+	if archive {
+		header = append(header, "archived")
+	}
+	header = append(header, "keep")
+	if overBudget {
+		header = append(header, "over budget")
+	}
+	return header
 }
@@ -0,0 +1,33 @@
+package ecrm_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fujiwara/ecrm"
+)
+
+func TestSummaryTableRegistryColumns(t *testing.T) {
+	sums := ecrm.NewRepoSummary("app")
+	for _, s := range sums {
+		s.Account, s.Region = "012345678901", "ap-northeast-1"
+	}
+	table := ecrm.SummaryTable(sums)
+
+	// a single registry in registries has the columns too
+	var b strings.Builder
+	if err := table.Print(&b, ecrm.FormatTable, true); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "ACCOUNT") || !strings.Contains(b.String(), "012345678901") {
+		t.Errorf("account and region should be shown in the table:\n%s", b.String())
+	}
+
+	b.Reset()
+	if err := table.Print(&b, ecrm.FormatTable, false); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), "ACCOUNT") {
+		t.Errorf("account and region should not be shown without registries:\n%s", b.String())
+	}
+}
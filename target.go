@@ -0,0 +1,94 @@
+package ecrm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// TargetConfig represents an AWS account and region to scan or to manage ECR registry.
+type TargetConfig struct {
+	Name       string `yaml:"name,omitempty"`
+	AccountID  string `yaml:"account_id,omitempty"`
+	Region     string `yaml:"region,omitempty"`
+	Profile    string `yaml:"profile,omitempty"`
+	RoleARN    string `yaml:"role_arn,omitempty"`
+	ExternalID string `yaml:"external_id,omitempty"`
+}
+
+func (t *TargetConfig) Validate() error {
+	if t.Name == "" && t.AccountID == "" && t.Region == "" && t.Profile == "" && t.RoleARN == "" {
+		return errors.New("targets require at least one of name, account_id, region, profile or role_arn")
+	}
+	if t.ExternalID != "" && t.RoleARN == "" {
+		return fmt.Errorf("target %s external_id requires role_arn", t)
+	}
+	return nil
+}
+
+func (t *TargetConfig) String() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	s := t.AccountID
+	if s == "" {
+		s = "(default account)"
+	}
+	if t.Region != "" {
+		s += "/" + t.Region
+	}
+	if t.Profile != "" {
+		s += " profile:" + t.Profile
+	}
+	if t.RoleARN != "" {
+		s += " role:" + t.RoleARN
+	}
+	return s
+}
+
+// AWSConfig returns aws.Config for the target.
+// The profile is loaded from the shared config, and the role is assumed with the credentials of the profile (or base).
+func (t *TargetConfig) AWSConfig(ctx context.Context, base aws.Config) (aws.Config, error) {
+	cfg := base.Copy()
+	if t.Profile != "" {
+		opts := []func(*awsConfig.LoadOptions) error{
+			awsConfig.WithSharedConfigProfile(t.Profile),
+		}
+		if t.Region != "" {
+			opts = append(opts, awsConfig.WithRegion(t.Region))
+		}
+		var err error
+		if cfg, err = awsConfig.LoadDefaultConfig(ctx, opts...); err != nil {
+			return aws.Config{}, fmt.Errorf("failed to load profile %s: %w", t.Profile, err)
+		}
+	}
+	if t.Region != "" {
+		cfg.Region = t.Region
+	}
+	if t.RoleARN != "" {
+		p := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), t.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = "ecrm"
+			if t.ExternalID != "" {
+				o.ExternalID = aws.String(t.ExternalID)
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(p)
+	}
+	if t.AccountID != "" {
+		out, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+		if err != nil {
+			return aws.Config{}, fmt.Errorf("failed to get caller identity of target %s: %w", t, err)
+		}
+		if aws.ToString(out.Account) != t.AccountID {
+			return aws.Config{}, fmt.Errorf("target %s account mismatch: credentials are for %s", t, aws.ToString(out.Account))
+		}
+	}
+	log.Printf("[debug] target %s region:%s", t, cfg.Region)
+	return cfg, nil
+}
@@ -0,0 +1,96 @@
+package ecrm_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/fujiwara/ecrm"
+)
+
+func TestTargetConfig(t *testing.T) {
+	tests := []struct {
+		target *ecrm.TargetConfig
+		valid  bool
+	}{
+		{&ecrm.TargetConfig{Region: "us-east-1"}, true},
+		{&ecrm.TargetConfig{Profile: "account-b"}, true},
+		{&ecrm.TargetConfig{RoleARN: "arn:aws:iam::012345678901:role/ecrm", ExternalID: "secret"}, true},
+		{&ecrm.TargetConfig{ExternalID: "secret", Region: "us-east-1"}, false},
+		{&ecrm.TargetConfig{}, false},
+	}
+	for _, tt := range tests {
+		err := tt.target.Validate()
+		if tt.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.target, err)
+		} else if !tt.valid && err == nil {
+			t.Errorf("%s: should be invalid", tt.target)
+		}
+	}
+}
+
+func TestTargetConfigAWSConfig(t *testing.T) {
+	base := aws.Config{Region: "ap-northeast-1"}
+	target := &ecrm.TargetConfig{Region: "us-east-1"}
+	cfg, err := target.AWSConfig(t.Context(), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Region != "us-east-1" {
+		t.Errorf("unexpected region: %s", cfg.Region)
+	}
+	if base.Region != "ap-northeast-1" {
+		t.Errorf("base config must not be modified: %s", base.Region)
+	}
+}
+
+func TestMergeTargetImages(t *testing.T) {
+	c := &ecrm.Config{
+		Clusters: []*ecrm.ClusterConfig{{NamePattern: "*"}},
+		Targets:  []*ecrm.TargetConfig{{Name: "prod"}, {Name: "stg"}},
+	}
+	prod := make(ecrm.Images)
+	prod.Add("012345678901.dkr.ecr.ap-northeast-1.amazonaws.com/app:v1", "prod")
+
+	imgs := make(ecrm.Images)
+	err := ecrm.MergeTargetImages(c, imgs, []ecrm.Images{prod, make(ecrm.Images)})
+	if err == nil {
+		t.Fatal("expected an error for the empty scan of the target stg")
+	}
+	if !strings.Contains(err.Error(), "target stg:") || strings.Contains(err.Error(), "target prod:") {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	imgs = make(ecrm.Images)
+	if err := ecrm.MergeTargetImages(c, imgs, []ecrm.Images{prod, prod}); err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 1 {
+		t.Errorf("unexpected merged images: %v", imgs)
+	}
+}
+
+func TestMergeTargetImagesNoWorkloads(t *testing.T) {
+	// no images are found in all targets although clusters are configured
+	c := &ecrm.Config{
+		Clusters: []*ecrm.ClusterConfig{{NamePattern: "*"}},
+		Targets:  []*ecrm.TargetConfig{{Name: "prod"}, {Name: "stg"}},
+	}
+	imgs := make(ecrm.Images)
+	err := ecrm.MergeTargetImages(c, imgs, []ecrm.Images{make(ecrm.Images), make(ecrm.Images)})
+	if err == nil {
+		t.Fatal("expected an error for the empty scan of all targets")
+	}
+	if !strings.Contains(err.Error(), "target prod:") || !strings.Contains(err.Error(), "target stg:") {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	// targets may have no images in use without clusters and lambda_functions
+	c = &ecrm.Config{
+		TaskDefinitions: []*ecrm.TaskdefConfig{{Name: "app"}},
+		Targets:         []*ecrm.TargetConfig{{Name: "prod"}, {Name: "stg"}},
+	}
+	if err := ecrm.MergeTargetImages(c, imgs, []ecrm.Images{make(ecrm.Images), make(ecrm.Images)}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
@@ -12,6 +12,7 @@ import (
 type taskdef struct {
 	name     string
 	revision int
+	usedBy   Consumer
 }
 
 func (td taskdef) String() string {